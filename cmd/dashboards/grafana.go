@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+)
+
+// Only the parts of the Grafana dashboard model the generator fills in.
+
+type dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	Editable      bool       `json:"editable"`
+	Refresh       string     `json:"refresh"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Version       int        `json:"version"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Datasource *datasource `json:"datasource,omitempty"`
+	Query      interface{} `json:"query"`
+	Definition string      `json:"definition,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"`
+	IncludeAll bool        `json:"includeAll"`
+	Multi      bool        `json:"multi"`
+	AllValue   string      `json:"allValue,omitempty"`
+	Current    current     `json:"current"`
+	Sort       int         `json:"sort,omitempty"`
+}
+
+type current struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type panel struct {
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	GridPos     gridPos      `json:"gridPos"`
+	Datasource  *datasource  `json:"datasource,omitempty"`
+	Collapsed   *bool        `json:"collapsed,omitempty"`
+	Panels      []panel      `json:"panels,omitempty"`
+	FieldConfig *fieldConfig `json:"fieldConfig,omitempty"`
+	Options     *options     `json:"options,omitempty"`
+	Targets     []target     `json:"targets,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fieldConfig struct {
+	Defaults  fieldDefaults `json:"defaults"`
+	Overrides []interface{} `json:"overrides"`
+}
+
+type fieldDefaults struct {
+	Unit   string      `json:"unit"`
+	Min    *float64    `json:"min,omitempty"`
+	Custom fieldCustom `json:"custom"`
+}
+
+type fieldCustom struct {
+	LineWidth   int    `json:"lineWidth"`
+	FillOpacity int    `json:"fillOpacity"`
+	ShowPoints  string `json:"showPoints"`
+}
+
+type options struct {
+	Legend  legend  `json:"legend"`
+	Tooltip tooltip `json:"tooltip"`
+}
+
+type legend struct {
+	DisplayMode string   `json:"displayMode"`
+	Placement   string   `json:"placement"`
+	Calcs       []string `json:"calcs"`
+}
+
+type tooltip struct {
+	Mode string `json:"mode"`
+	Sort string `json:"sort"`
+}
+
+type target struct {
+	RefID        string      `json:"refId"`
+	Datasource   *datasource `json:"datasource"`
+	Expr         string      `json:"expr"`
+	LegendFormat string      `json:"legendFormat"`
+}
+
+var prometheusDS = &datasource{Type: "prometheus", UID: "${datasource}"}
+
+// filters are the dashboard variables panels are filtered by, when their
+// metric has the label.
+var filters = []string{"system", "event_type"}
+
+const rateWindow = "$__rate_interval"
+
+// buildDashboard lays the panels of every definition out in rows, one row
+// per telemetry group. A definition without one of those groups would have
+// no panel, so it is an error.
+func buildDashboard(defs []telemetry.Definition) (dashboard, error) {
+	groups := map[string]bool{}
+	for _, g := range telemetry.Groups {
+		groups[g] = true
+	}
+	byName := map[string]telemetry.Definition{}
+	for _, d := range defs {
+		switch {
+		case d.Group == "":
+			return dashboard{}, fmt.Errorf("metric %s has no group", d.Name)
+		case !groups[d.Group]:
+			return dashboard{}, fmt.Errorf("metric %s has unknown group %q", d.Name, d.Group)
+		}
+		byName[d.Name] = d
+	}
+
+	l := &layout{}
+	for _, group := range telemetry.Groups {
+		started := false
+		for _, d := range defs {
+			if d.Group != group {
+				continue
+			}
+			if !started {
+				l.row(group)
+				started = true
+			}
+			// Keep the quantiles of a histogram side by side on one line.
+			if d.Kind == telemetry.KindHistogram {
+				l.newLine()
+			}
+			for _, p := range panelsFor(d, byName) {
+				l.add(p)
+			}
+		}
+	}
+
+	return dashboard{
+		UID:           "event-pipeline",
+		Title:         "Event Pipeline Monitoring",
+		Description:   "Generated by cmd/dashboards from the metric definitions in internal/telemetry. Do not edit by hand.",
+		Tags:          []string{"event-pipeline", "generated"},
+		Timezone:      "browser",
+		Editable:      true,
+		Refresh:       "30s",
+		SchemaVersion: 39,
+		Version:       1,
+		Time:          timeRange{From: "now-1h", To: "now"},
+		Templating:    templating{List: variables(defs)},
+		Panels:        l.panels,
+	}, nil
+}
+
+func variables(defs []telemetry.Definition) []variable {
+	vars := []variable{{
+		Name:    "datasource",
+		Label:   "Data source",
+		Type:    "datasource",
+		Query:   "prometheus",
+		Current: current{Text: "Prometheus", Value: "Prometheus"},
+	}}
+	for _, label := range filters {
+		source := ""
+		for _, d := range defs {
+			if hasLabel(d, label) {
+				source = metricName(d)
+				break
+			}
+		}
+		if source == "" {
+			continue
+		}
+		q := fmt.Sprintf("label_values(%s, %s)", source, label)
+		vars = append(vars, variable{
+			Name:       label,
+			Label:      label,
+			Type:       "query",
+			Datasource: prometheusDS,
+			Query:      map[string]string{"query": q, "refId": label},
+			Definition: q,
+			Refresh:    2, // on time range change
+			IncludeAll: true,
+			Multi:      true,
+			AllValue:   ".*",
+			Current:    current{Text: "All", Value: "$__all"},
+			Sort:       1,
+		})
+	}
+	return vars
+}
+
+// panelsFor returns the panels of one metric: its rate, value or quantiles
+// depending on its kind, and its share of another counter when it has a
+// Ratio.
+func panelsFor(d telemetry.Definition, byName map[string]telemetry.Definition) []panel {
+	title := d.Title
+	if title == "" {
+		title = d.Help
+	}
+	note := d.Note
+	if note == "" {
+		note = d.Help
+	}
+	note += fmt.Sprintf(" (%s)", d.Name)
+	by := d.By
+	if by == nil {
+		by = d.Labels
+	}
+
+	var panels []panel
+	switch d.Kind {
+	case telemetry.KindCounter:
+		expr := fmt.Sprintf("sum%s (rate(%s%s[%s]))", byClause(by), d.Name, selector(d), rateWindow)
+		panels = append(panels, timeseries(title+" per second", note, unit(d, "cps"), 12, target{Expr: expr, LegendFormat: legendFor(by, title)}))
+
+		if ratio, ok := byName[d.Ratio]; ok {
+			common := intersect(by, ratio.Labels)
+			expr := fmt.Sprintf("sum%s (rate(%s%s[%s]))\n/ on(%s) group_left\nsum%s (rate(%s%s[%s]))",
+				byClause(by), d.Name, selector(d), rateWindow,
+				strings.Join(common, ", "),
+				byClause(common), ratio.Name, selector(ratio), rateWindow)
+			ratioTitle := ratio.Title
+			if ratioTitle == "" {
+				ratioTitle = ratio.Name
+			}
+			panels = append(panels, timeseries(fmt.Sprintf("%s as share of %s", title, strings.ToLower(ratioTitle)), note, "percentunit", 12, target{Expr: expr, LegendFormat: legendFor(by, title)}))
+		}
+	case telemetry.KindGauge:
+		expr := fmt.Sprintf("sum%s (%s%s)", byClause(by), d.Name, selector(d))
+		panels = append(panels, timeseries(title, note, unit(d, "short"), 12, target{Expr: expr, LegendFormat: legendFor(by, title)}))
+	case telemetry.KindHistogram:
+		for _, q := range []float64{0.5, 0.95, 0.99} {
+			expr := fmt.Sprintf("histogram_quantile(%g, sum by (%s) (rate(%s_bucket%s[%s])))",
+				q, strings.Join(append([]string{"le"}, by...), ", "), d.Name, selector(d), rateWindow)
+			panels = append(panels, timeseries(fmt.Sprintf("%s p%g", title, q*100), note, unit(d, "s"), 8, target{Expr: expr, LegendFormat: legendFor(by, title)}))
+		}
+	}
+	return panels
+}
+
+func timeseries(title, description, unit string, width int, t target) panel {
+	t.RefID = "A"
+	t.Datasource = prometheusDS
+	zero := 0.0
+	return panel{
+		Type:        "timeseries",
+		Title:       title,
+		Description: description,
+		GridPos:     gridPos{H: 8, W: width},
+		Datasource:  prometheusDS,
+		FieldConfig: &fieldConfig{
+			Defaults: fieldDefaults{
+				Unit:   unit,
+				Min:    &zero,
+				Custom: fieldCustom{LineWidth: 1, FillOpacity: 10, ShowPoints: "never"},
+			},
+			Overrides: []interface{}{},
+		},
+		Options: &options{
+			Legend:  legend{DisplayMode: "table", Placement: "bottom", Calcs: []string{"mean", "max", "lastNotNull"}},
+			Tooltip: tooltip{Mode: "multi", Sort: "desc"},
+		},
+		Targets: []target{t},
+	}
+}
+
+// layout places panels left to right, wrapping at Grafana's 24 columns.
+type layout struct {
+	panels []panel
+	x, y   int
+	rowH   int
+	nextID int
+}
+
+func (l *layout) row(title string) {
+	l.newLine()
+	collapsed := false
+	l.nextID++
+	l.panels = append(l.panels, panel{
+		ID:        l.nextID,
+		Type:      "row",
+		Title:     title,
+		GridPos:   gridPos{H: 1, W: 24, X: 0, Y: l.y},
+		Collapsed: &collapsed,
+		Panels:    []panel{},
+	})
+	l.y++
+}
+
+func (l *layout) add(p panel) {
+	if l.x+p.GridPos.W > 24 {
+		l.newLine()
+	}
+	l.nextID++
+	p.ID = l.nextID
+	p.GridPos.X, p.GridPos.Y = l.x, l.y
+	l.panels = append(l.panels, p)
+	l.x += p.GridPos.W
+	l.rowH = max(l.rowH, p.GridPos.H)
+}
+
+func (l *layout) newLine() {
+	l.y += l.rowH
+	l.x, l.rowH = 0, 0
+}
+
+// selector filters a metric by the dashboard variables it has labels for.
+func selector(d telemetry.Definition) string {
+	var matchers []string
+	for _, label := range filters {
+		if hasLabel(d, label) {
+			matchers = append(matchers, fmt.Sprintf(`%s=~"$%s"`, label, label))
+		}
+	}
+	if len(matchers) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(matchers, ", ") + "}"
+}
+
+func byClause(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return " by (" + strings.Join(labels, ", ") + ")"
+}
+
+func legendFor(labels []string, fallback string) string {
+	if len(labels) == 0 {
+		return fallback
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = "{{" + l + "}}"
+	}
+	return strings.Join(parts, " ")
+}
+
+func unit(d telemetry.Definition, fallback string) string {
+	if d.Unit != "" {
+		return d.Unit
+	}
+	return fallback
+}
+
+// metricName is the series name label_values can read a metric's labels
+// from.
+func metricName(d telemetry.Definition) string {
+	if d.Kind == telemetry.KindHistogram {
+		return d.Name + "_count"
+	}
+	return d.Name
+}
+
+func hasLabel(d telemetry.Definition, label string) bool {
+	for _, l := range d.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func intersect(a, b []string) []string {
+	var out []string
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				out = append(out, x)
+				break
+			}
+		}
+	}
+	return out
+}
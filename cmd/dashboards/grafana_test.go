@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+)
+
+func TestBuildDashboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		group   string
+		wantErr bool
+	}{
+		{name: "known group", group: telemetry.GroupHealth},
+		{name: "no group", group: "", wantErr: true},
+		{name: "unknown group", group: "Misc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs := []telemetry.Definition{{
+				Name:  "test_total",
+				Help:  "Total number of tests",
+				Kind:  telemetry.KindCounter,
+				Group: tt.group,
+			}}
+			board, err := buildDashboard(defs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("built a dashboard, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(board.Panels) == 0 {
+				t.Error("dashboard has no panels")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+	"gopkg.in/yaml.v3"
+)
+
+const generatedHeader = "# Generated by cmd/dashboards from the metric definitions in internal/telemetry. DO NOT EDIT.\n"
+
+// dashboards writes the Grafana dashboard and the Prometheus alerting rules
+// for the metrics defined in internal/telemetry. With -check it only
+// reports whether the files on disk are out of date.
+func main() {
+	var (
+		dir   = flag.String("dir", "cmd/monitoring", "Directory the files are written to")
+		check = flag.Bool("check", false, "Exit with an error if the files are not up to date instead of writing them")
+	)
+	flag.Parse()
+	ctx := context.Background()
+
+	defs := telemetry.Definitions()
+
+	board, err := buildDashboard(defs)
+	if err != nil {
+		logging.Fatal(ctx, "failed to build dashboard", logging.Err(err))
+	}
+	dash, err := json.MarshalIndent(board, "", "  ")
+	if err != nil {
+		logging.Fatal(ctx, "failed to encode dashboard", logging.Err(err))
+	}
+	dash = append(dash, '\n')
+
+	var rules bytes.Buffer
+	rules.WriteString(generatedHeader)
+	enc := yaml.NewEncoder(&rules)
+	enc.SetIndent(2)
+	if err := enc.Encode(buildRules(defs)); err != nil {
+		logging.Fatal(ctx, "failed to encode alert rules", logging.Err(err))
+	}
+
+	files := map[string][]byte{
+		filepath.Join(*dir, "grafana-dashboard.json"): dash,
+		filepath.Join(*dir, "alerts.yml"):             rules.Bytes(),
+	}
+	stale := false
+	for path, data := range files {
+		if *check {
+			current, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(current, data) {
+				logging.Error(ctx, "generated file is out of date, run make dashboards", "path", path)
+				stale = true
+			}
+			continue
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			logging.Fatal(ctx, "failed to write file", "path", path, logging.Err(err))
+		}
+		logging.Info(ctx, "wrote file", "path", path)
+	}
+	if stale {
+		os.Exit(1)
+	}
+}
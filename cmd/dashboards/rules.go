@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+)
+
+// ruleFile is the Prometheus rule file format.
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// buildRules collects the alerts of every definition into one group.
+func buildRules(defs []telemetry.Definition) ruleFile {
+	group := ruleGroup{Name: "event-pipeline"}
+	for _, d := range defs {
+		for _, a := range d.Alerts {
+			group.Rules = append(group.Rules, rule{
+				Alert:  a.Name,
+				Expr:   a.Expr,
+				For:    a.For,
+				Labels: map[string]string{"severity": a.Severity},
+				Annotations: map[string]string{
+					"summary":     a.Summary,
+					"description": d.Help + " (" + d.Name + ")",
+				},
+			})
+		}
+	}
+	return ruleFile{Groups: []ruleGroup{group}}
+}
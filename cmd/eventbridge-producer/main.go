@@ -2,19 +2,33 @@ package main
 
 import (
 	"context"
-	"log"
+	"os"
 	"time"
 
 	"github.com/Babatunde13/event-pipeline/internal/config"
 	"github.com/Babatunde13/event-pipeline/internal/event"
 	"github.com/Babatunde13/event-pipeline/internal/eventbridge"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+	"github.com/Babatunde13/event-pipeline/internal/tracing"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var ginLambda *ginadapter.GinLambda
+var (
+	ginLambda *ginadapter.GinLambda
+	// metrics pushes to the Pushgateway when running under Lambda, where
+	// nothing can scrape the producer.
+	metrics *telemetry.Pusher
+)
+
+var tracer = tracing.Tracer("github.com/Babatunde13/event-pipeline/cmd/eventbridge-producer")
 
 type router struct {
 	eb *eventbridge.Client
@@ -22,45 +36,95 @@ type router struct {
 
 func init() {
 	config.Load("event-pipeline-secret")
+	logging.Init("eventbridge-producer", config.Cfg.LogLevel, config.Cfg.SampleRate())
+	if err := tracing.Init(context.Background(), "eventbridge-producer", config.Cfg.OtelEndpoint); err != nil {
+		logging.Error(context.Background(), "failed to set up tracing", logging.Err(err))
+	}
 }
 
 func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	return ginLambda.ProxyWithContext(ctx, req)
+	resp, err := ginLambda.ProxyWithContext(ctx, req)
+	tracing.Flush(ctx)
+	// The environment may be frozen until the next request, and the
+	// interval flush with it.
+	metrics.Flush()
+	return resp, err
+}
+
+// requestContext adds the request id to ctx: the Lambda invocation id, or
+// the X-Request-Id header when running as an HTTP server.
+func requestContext(c *gin.Context, ctx context.Context) context.Context {
+	if id := c.GetHeader("X-Request-Id"); id != "" {
+		return logging.With(ctx, logging.KeyRequestID, id)
+	}
+	return logging.WithLambda(ctx)
 }
 
 func (r *router) sendEvent(c *gin.Context) {
+	// Continue the caller's trace when the request carries one.
+	ctx := tracing.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+c.FullPath(), trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	ctx = logging.With(requestContext(c, ctx), logging.KeySystem, telemetry.SystemEventBridge)
+
 	var e event.Event
 	if err := c.ShouldBindJSON(&e); err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	span.SetAttributes(
+		attribute.String("event.id", e.EventID),
+		attribute.String("event.type", string(e.EventType)),
+	)
 
-	log.Printf("Received event: %s - %s", e.EventType, e.EventID)
-	e.Timestamp = time.Now().UTC().UnixMilli() // Ensure timestamp is set to current time
-	err := r.eb.PutEvent(context.Background(), config.Cfg.EventBusSource, string(e.EventType), e)
+	ctx = logging.WithEvent(ctx, e.EventID, string(e.EventType))
+	logging.Sampled(ctx, "received event")
+	received := time.Now()
+	e.Timestamp = received.UTC().UnixMilli() // Ensure timestamp is set to current time
+	e.PublishedAt = event.Now()
+	err := r.eb.PutEvent(ctx, config.Cfg.EventBusSource, string(e.EventType), &e)
 
 	if err != nil {
-		log.Printf("failed to send event: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		logging.Error(ctx, "failed to send event", logging.Err(err))
 		c.JSON(500, gin.H{"error": err.Error()})
 	} else {
-		log.Printf("event sent: %s - %s", e.EventType, e.EventID)
+		telemetry.ObserveIngest(telemetry.SystemEventBridge, e.EventType.Label(), time.Since(received))
+		logging.Sampled(ctx, "event sent")
 		c.JSON(200, gin.H{"status": "ok"})
 	}
 }
 
 func main() {
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	// gin.Default would add a plain-text request logger; requests are
+	// already counted and timed by the telemetry middleware.
+	r := gin.New()
 	r.Use(gin.Recovery())
+	r.Use(telemetry.GinMiddleware(telemetry.SystemEventBridge))
 	eb := eventbridge.New(*config.Cfg.AwsConfig, config.Cfg.EventBusName)
-	log.Println("EventBridge client initialized with bus name:", config.Cfg.EventBusName)
+	logging.Info(context.Background(), "EventBridge client initialized", "bus", config.Cfg.EventBusName)
 	api := &router{eb: eb}
 	r.POST("/event/eventbridge", api.sendEvent)
 	r.NoRoute(func(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "not found"})
 	})
 
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		if err := telemetry.ServeRouter(r, addr, os.Getenv("METRICS_ADDR")); err != nil {
+			logging.Fatal(context.Background(), "HTTP server failed", logging.Err(err))
+		}
+		return
+	}
+
+	metrics = telemetry.NewPusher(config.Cfg.PrometheusPushGatewayUrl)
+	metrics.Start(config.Cfg.MetricsInterval())
+
 	ginLambda = ginadapter.New(r)
-	log.Println("Starting lambda server....")
-	lambda.Start(handler)
+	logging.Info(context.Background(), "starting lambda server")
+	lambda.StartWithOptions(handler, lambda.WithEnableSIGTERM(func() {
+		metrics.Stop()
+		tracing.Shutdown(context.Background())
+	}))
 }
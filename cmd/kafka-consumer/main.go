@@ -4,68 +4,227 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
+	"time"
 
 	"github.com/Babatunde13/event-pipeline/internal/config"
 	"github.com/Babatunde13/event-pipeline/internal/database"
 	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/storage"
 	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+	"github.com/Babatunde13/event-pipeline/internal/tracing"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var ddb database.Database
+var tracer = tracing.Tracer("github.com/Babatunde13/event-pipeline/cmd/kafka-consumer")
 
-func init() {
+var (
+	ddb     database.Database
+	metrics *telemetry.Pusher
+)
+
+// setup opens the sinks and telemetry configured in the secret. It is
+// called from main rather than init so that tests can run processBatch
+// against an in-memory database instead.
+func setup() {
 	config.Load("event-pipeline-secret")
-	ddb = database.NewDynamo(config.Cfg.AwsConfig)
+	logging.Init("kafka-consumer", config.Cfg.LogLevel, config.Cfg.SampleRate())
+	var err error
+	ddb, err = storage.Open(context.Background(), &config.Cfg)
+	if err != nil {
+		logging.Fatal(context.Background(), "unable to open database", logging.Err(err))
+	}
+	if err := tracing.Init(context.Background(), "kafka-consumer", config.Cfg.OtelEndpoint); err != nil {
+		logging.Error(context.Background(), "failed to set up tracing", logging.Err(err))
+	}
+	metrics = telemetry.NewPusher(config.Cfg.PrometheusPushGatewayUrl)
+	metrics.Start(config.Cfg.MetricsInterval())
 }
 
 func processBatch(ctx context.Context, batch []events.KafkaRecord) {
+	decoded := make([]*event.Event, 0, len(batch))
+	received := make([]time.Time, 0, len(batch))
+	spans := make([]trace.Span, 0, len(batch))
+	contexts := make([]context.Context, 0, len(batch))
+	links := make([]trace.Link, 0, len(batch))
+	seen := make(map[string]bool, len(batch))
 	for _, record := range batch {
+		receivedAt := time.Now()
+		// Each record continues the trace its producer started.
+		rctx, span := tracer.Start(tracing.Extract(ctx, headerCarrier(record.Headers)), "kafka.process "+record.Topic,
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "kafka"),
+				attribute.String("messaging.destination.name", record.Topic),
+				attribute.Int64("messaging.kafka.destination.partition", record.Partition),
+				attribute.Int64("messaging.kafka.message.offset", record.Offset),
+			))
+		rctx = logging.WithRecord(rctx, record.Topic, record.Partition, record.Offset)
+
 		msg, err := base64.StdEncoding.DecodeString(record.Value)
 		if err != nil {
-			log.Printf("failed to decode message: %v", err)
+			logging.Warn(rctx, "failed to decode message", logging.Err(err))
+			telemetry.RecordFailure(telemetry.SystemKafka, event.LabelOther, telemetry.ErrDecode)
+			tracing.End(span, err)
 			continue
 		}
 		if len(msg) == 0 {
-			log.Println("Empty message, skipping")
+			logging.Warn(rctx, "empty message, skipping")
+			telemetry.RecordFailure(telemetry.SystemKafka, event.LabelOther, telemetry.ErrDecode)
+			tracing.End(span, errors.New("empty message"))
 			continue
 		}
-		log.Printf("Message: topic=%s partition=%d offset=%d key=%q",
-			record.Topic, record.Partition, record.Offset, string(record.Key))
 
 		var e event.Event
 		if err := json.Unmarshal(msg, &e); err != nil {
-			log.Printf("Invalid event data: %v", err)
+			logging.Warn(rctx, "invalid event data", logging.Err(err))
+			telemetry.RecordFailure(telemetry.SystemKafka, event.LabelOther, telemetry.ErrDecode)
+			tracing.End(span, err)
+			continue
+		}
+		span.SetAttributes(
+			attribute.String("event.id", e.EventID),
+			attribute.String("event.type", string(e.EventType)),
+		)
+		rctx = logging.WithEvent(rctx, e.EventID, string(e.EventType))
+		logging.Sampled(rctx, "received message", "key", string(record.Key))
+		telemetry.RecordReceived(telemetry.SystemKafka, e.EventType.Label())
+		if err := e.Validate(); err != nil {
+			logging.Warn(rctx, "invalid event", logging.Err(err))
+			telemetry.RecordFailure(telemetry.SystemKafka, e.EventType.Label(), telemetry.ErrValidation)
+			tracing.End(span, err)
+			continue
+		}
+		// A redelivered batch can carry the same event twice; only the first
+		// is stored.
+		if seen[e.EventID] {
+			logging.Sampled(rctx, "duplicate event in batch, skipping")
+			telemetry.RecordDuplicate(telemetry.SystemKafka, e.EventType.Label())
+			span.SetAttributes(attribute.Bool("event.duplicate", true))
+			span.End()
 			continue
 		}
+		seen[e.EventID] = true
 
-		err = e.Save(ctx, ddb, event.SourceKafka)
-		telemetry.PushMetrics(config.Cfg.PrometheusPushGatewayUrl, e.Duration(), true, err == nil)
+		e.ConsumedAt = receivedAt.UTC().UnixMilli()
+		// Transport starts when the broker appended the record, which the
+		// record carries only on topics with message.timestamp.type=LogAppendTime.
+		if record.TimestampType == "LOG_APPEND_TIME" && !record.Timestamp.IsZero() {
+			telemetry.ObserveTransport(telemetry.SystemKafka, e.EventType.Label(), event.Between(record.Timestamp.UnixMilli(), e.ConsumedAt))
+		} else {
+			logging.Sampled(rctx, "record has no log append time, skipping transport latency", "timestamp_type", record.TimestampType)
+		}
+		decoded = append(decoded, &e)
+		received = append(received, receivedAt)
+		spans = append(spans, span)
+		contexts = append(contexts, rctx)
+		links = append(links, trace.Link{SpanContext: span.SpanContext()})
+	}
+	if len(decoded) == 0 {
+		return
+	}
 
+	// The batch is written in one go, so its span links every record's trace
+	// instead of belonging to any one of them.
+	storeCtx, storeSpan := tracer.Start(ctx, "store batch", trace.WithLinks(links...))
+	errs := event.SaveBatch(storeCtx, ddb, event.SourceKafka, decoded)
+	if err := flushStorage(storeCtx); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	storeSpan.End()
+	for i, e := range decoded {
+		err := errs[i]
+		tracing.End(spans[i], err)
 		if err != nil {
-			log.Printf("Save failed: %v", err)
-		} else {
-			log.Printf("Event processed: %s - %s", e.EventType, e.EventID)
+			logging.Error(contexts[i], "save failed", logging.Err(err))
+			class := telemetry.ErrStorageOther
+			if database.IsThrottled(err) {
+				class = telemetry.ErrStorageThrottled
+			}
+			telemetry.RecordFailure(telemetry.SystemKafka, e.EventType.Label(), class)
+			continue
 		}
+		telemetry.ObserveSink(telemetry.SystemKafka, e.EventType.Label(), time.Since(received[i]))
+		telemetry.RecordSuccess(telemetry.SystemKafka, e.EventType.Label(), e.Duration())
+		logging.Sampled(contexts[i], "event processed")
 	}
 }
 
+// headerCarrier reads trace context from the headers of a Kafka record as
+// delivered to Lambda.
+type headerCarrier []map[string]events.JSONNumberBytes
+
+func (c headerCarrier) Get(key string) string {
+	for _, h := range c {
+		if v, ok := h[key]; ok {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {}
+
+func (c headerCarrier) Keys() []string {
+	var keys []string
+	for _, h := range c {
+		for k := range h {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
 func handler(ctx context.Context, payload events.KafkaEvent) {
-	log.Println("Kafka consumer initialized with topic:", config.Cfg.KafkaTopic)
+	ctx, span := tracer.Start(ctx, "kafka-consumer handler")
+	ctx = logging.With(logging.WithLambda(ctx), logging.KeySystem, telemetry.SystemKafka)
 
 	if len(payload.Records) > 0 {
 		for partKey, batch := range payload.Records {
-			log.Printf("Processing partition key: %s with %d records", partKey, len(batch))
+			logging.Debug(ctx, "processing partition", "partition_key", partKey, "records", len(batch))
 			processBatch(ctx, batch)
 		}
 	} else {
-		log.Printf("Received empty payload for %s", payload.EventSourceARN)
+		logging.Info(ctx, "received empty payload", "event_source_arn", payload.EventSourceARN)
+	}
+	// Push once per invocation, after every record has been handled, so the
+	// push never adds to the latencies being measured.
+	metrics.Flush()
+	// The handler span has to end before the flush to be exported with it.
+	span.End()
+	tracing.Flush(ctx)
+}
+
+// flushStorage makes the writes that sinks such as the Parquet archive only
+// buffered durable before the batch is reported stored, since the Lambda
+// environment can be frozen or killed before they flush on their own.
+func flushStorage(ctx context.Context) error {
+	if f, ok := ddb.(database.Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+// shutdown writes out events still buffered by sinks such as the Parquet
+// archive and the metrics not yet pushed before the Lambda environment goes
+// away.
+func shutdown() {
+	if err := flushStorage(context.Background()); err != nil {
+		logging.Error(context.Background(), "failed to flush storage on shutdown", logging.Err(err))
 	}
+	metrics.Stop()
+	tracing.Shutdown(context.Background())
 }
 
 func main() {
-	lambda.Start(handler)
-	log.Println("Lambda function started")
+	setup()
+	lambda.StartWithOptions(handler, lambda.WithEnableSIGTERM(shutdown))
 }
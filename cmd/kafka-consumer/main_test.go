@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/Babatunde13/event-pipeline/internal/database"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func record(offset int64, value []byte) events.KafkaRecord {
+	return events.KafkaRecord{
+		Topic:     "events",
+		Partition: 0,
+		Offset:    offset,
+		Value:     base64.StdEncoding.EncodeToString(value),
+	}
+}
+
+func eventRecord(t *testing.T, offset int64, e event.Event) events.KafkaRecord {
+	t.Helper()
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record(offset, data)
+}
+
+func storedIDs(t *testing.T, db database.Reader) []string {
+	t.Helper()
+	var stored []event.Event
+	if _, err := db.Scan(context.Background(), "events", database.Scan{}, &stored); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(stored))
+	for i, e := range stored {
+		if e.Source != event.SourceKafka || e.ConsumedAt == 0 || e.StoredAt == 0 {
+			t.Errorf("event %s stored without its source and hop timestamps: %+v", e.EventID, e)
+		}
+		ids[i] = e.EventID
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestProcessBatch(t *testing.T) {
+	view := event.Event{EventID: "e1", Timestamp: 1, EventType: event.ViewProduct, UserID: "u1"}
+	cart := event.Event{EventID: "e2", Timestamp: 2, EventType: event.AddToCart, UserID: "u1"}
+	tests := []struct {
+		name   string
+		tables bool
+		batch  func(t *testing.T) []events.KafkaRecord
+		want   []string
+	}{
+		{
+			name:   "valid events",
+			tables: true,
+			batch: func(t *testing.T) []events.KafkaRecord {
+				return []events.KafkaRecord{eventRecord(t, 1, view), eventRecord(t, 2, cart)}
+			},
+			want: []string{"e1", "e2"},
+		},
+		{
+			name:   "redelivered event stored once",
+			tables: true,
+			batch: func(t *testing.T) []events.KafkaRecord {
+				return []events.KafkaRecord{eventRecord(t, 1, view), eventRecord(t, 2, cart), eventRecord(t, 1, view)}
+			},
+			want: []string{"e1", "e2"},
+		},
+		{
+			name:   "bad records skipped",
+			tables: true,
+			batch: func(t *testing.T) []events.KafkaRecord {
+				bad := events.KafkaRecord{Topic: "events", Offset: 3, Value: "not base64!"}
+				noUser := view
+				noUser.EventID, noUser.UserID = "e3", ""
+				return []events.KafkaRecord{
+					eventRecord(t, 1, view),
+					bad,
+					record(4, nil),
+					record(5, []byte("{not json")),
+					eventRecord(t, 6, noUser),
+				}
+			},
+			want: []string{"e1"},
+		},
+		{
+			name:   "storage failure",
+			tables: false,
+			batch: func(t *testing.T) []events.KafkaRecord {
+				return []events.KafkaRecord{eventRecord(t, 1, view)}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := database.NewMemory()
+			if tt.tables {
+				if err := event.CreateTables(context.Background(), mem); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ddb = mem
+			processBatch(context.Background(), tt.batch(t))
+			if !tt.tables {
+				return
+			}
+			if got := storedIDs(t, mem); !slices.Equal(got, tt.want) {
+				t.Errorf("stored %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/config"
+	"github.com/Babatunde13/event-pipeline/internal/kafka"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+)
+
+// lag-exporter reads the offsets of Kafka consumer groups on an interval and
+// serves them as Prometheus gauges on /metrics.
+func main() {
+	var (
+		addr     = flag.String("addr", ":9308", "Address to serve /metrics on")
+		interval = flag.Duration("interval", 15*time.Second, "How often offsets are read")
+		groups   = flag.String("group", "", "Comma-separated consumer groups, default KAFKA_CONSUMER_GROUP")
+		topic    = flag.String("topic", "", "Topic to report on, default KAFKA_TOPIC")
+	)
+	flag.Parse()
+
+	config.Load("event-pipeline-secret")
+	logging.Init("lag-exporter", config.Cfg.LogLevel, config.Cfg.SampleRate())
+	ctx := context.Background()
+
+	if *groups == "" {
+		*groups = config.Cfg.KafkaConsumerGroup
+	}
+	if *topic == "" {
+		*topic = config.Cfg.KafkaTopic
+	}
+	if *groups == "" || *topic == "" {
+		logging.Fatal(ctx, "a consumer group and a topic are required")
+	}
+
+	var readers []*kafka.LagReader
+	for _, group := range strings.Split(*groups, ",") {
+		r, err := kafka.NewLagReader(strings.TrimSpace(group))
+		if err != nil {
+			logging.Fatal(ctx, "failed to create lag reader", "group", group, logging.Err(err))
+		}
+		readers = append(readers, r)
+	}
+
+	go func() {
+		logging.Info(ctx, "serving metrics", "addr", *addr, "path", "/metrics")
+		if err := telemetry.ServeLag(*addr); err != nil {
+			logging.Fatal(ctx, "metrics server failed", logging.Err(err))
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		for _, r := range readers {
+			record(ctx, r, *topic)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, r := range readers {
+				if err := r.Close(); err != nil {
+					logging.Error(context.Background(), "failed to close lag reader", "group", r.Group(), logging.Err(err))
+				}
+			}
+			return
+		}
+	}
+}
+
+func record(ctx context.Context, r *kafka.LagReader, topic string) {
+	ctx = logging.With(ctx, "group", r.Group(), logging.KeyTopic, topic)
+	lags, err := r.Lag(ctx, topic)
+	if err != nil {
+		logging.Error(ctx, "failed to read consumer lag", logging.Err(err))
+		telemetry.RecordLagError(r.Group(), topic)
+		return
+	}
+	var total int64
+	for _, l := range lags {
+		telemetry.SetPartitionLag(r.Group(), l.Topic, l.Partition, l.Committed, l.High, l.Lag)
+		total += l.Lag
+	}
+	logging.Debug(ctx, "read consumer lag", "partitions", len(lags), "lag", total)
+}
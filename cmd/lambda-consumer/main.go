@@ -3,45 +3,145 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/Babatunde13/event-pipeline/internal/config"
 	"github.com/Babatunde13/event-pipeline/internal/database"
 	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/storage"
 	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+	"github.com/Babatunde13/event-pipeline/internal/tracing"
 )
 
-var ddb database.Database
+var tracer = tracing.Tracer("github.com/Babatunde13/event-pipeline/cmd/lambda-consumer")
 
-func init() {
+var (
+	ddb     database.Database
+	metrics *telemetry.Pusher
+)
+
+// setup opens the sinks and telemetry configured in the secret. Tests skip
+// it and point ddb at an in-memory database.
+func setup() {
 	config.Load("event-pipeline-secret")
-	ddb = database.NewDynamo(config.Cfg.AwsConfig)
+	logging.Init("lambda-consumer", config.Cfg.LogLevel, config.Cfg.SampleRate())
+	var err error
+	ddb, err = storage.Open(context.Background(), &config.Cfg)
+	if err != nil {
+		logging.Fatal(context.Background(), "unable to open database", logging.Err(err))
+	}
+	if err := tracing.Init(context.Background(), "lambda-consumer", config.Cfg.OtelEndpoint); err != nil {
+		logging.Error(context.Background(), "failed to set up tracing", logging.Err(err))
+	}
+	metrics = telemetry.NewPusher(config.Cfg.PrometheusPushGatewayUrl)
+	metrics.Start(config.Cfg.MetricsInterval())
 }
 
 func handler(ctx context.Context, ebEvent events.EventBridgeEvent) error {
-	log.Printf("Received EventBridge event: source=%s type=%s id=%s", ebEvent.Source, ebEvent.DetailType, ebEvent.ID)
+	received := time.Now()
+	ctx = logging.With(logging.WithLambda(ctx), logging.KeySystem, telemetry.SystemEventBridge, "eventbridge_id", ebEvent.ID)
 
 	var e event.Event
 	if err := json.Unmarshal(ebEvent.Detail, &e); err != nil {
-		log.Printf("failed to parse event detail: %v", err)
+		logging.Warn(ctx, "failed to parse event detail", "source", ebEvent.Source, "detail_type", ebEvent.DetailType, logging.Err(err))
+		telemetry.RecordFailure(telemetry.SystemEventBridge, event.LabelOther, telemetry.ErrDecode)
+		metrics.Flush()
 		return nil // continue to next
 	}
 
-	log.Printf("[EventBridge] received event: %s - %s", e.EventType, e.EventID)
+	// The trace context rode along in the detail; it is not stored.
+	ctx, span := tracer.Start(tracing.Extract(ctx, propagation.MapCarrier(e.TraceContext)), "eventbridge.process "+ebEvent.DetailType,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_eventbridge"),
+			attribute.String("messaging.message.id", ebEvent.ID),
+			attribute.String("event.id", e.EventID),
+			attribute.String("event.type", string(e.EventType)),
+		))
+	e.TraceContext = nil
+	ctx = logging.WithEvent(ctx, e.EventID, string(e.EventType))
+	logging.Sampled(ctx, "received event", "source", ebEvent.Source)
+	defer func() {
+		span.End()
+		tracing.Flush(ctx)
+		// The interval flush does not run while the environment is frozen
+		// between invocations, so push before returning.
+		metrics.Flush()
+	}()
+
+	telemetry.RecordReceived(telemetry.SystemEventBridge, e.EventType.Label())
+	if err := e.Validate(); err != nil {
+		logging.Warn(ctx, "invalid event", logging.Err(err))
+		telemetry.RecordFailure(telemetry.SystemEventBridge, e.EventType.Label(), telemetry.ErrValidation)
+		span.SetStatus(codes.Error, err.Error())
+		return nil
+	}
 
-	err := e.Save(ctx, ddb, event.SourceEventBridge)
-	telemetry.PushMetrics(config.Cfg.PrometheusPushGatewayUrl, e.Duration(), false, err == nil)
+	e.ConsumedAt = received.UTC().UnixMilli()
+	// EventBridge only stamps events with second precision, so transport is
+	// measured from the producer's hand-off and includes the PutEvents call.
+	telemetry.ObserveTransport(telemetry.SystemEventBridge, e.EventType.Label(), event.Between(e.PublishedAt, e.ConsumedAt))
 
-	if err != nil {
-		log.Printf("failed to store event in DynamoDB: %v", err)
+	// EventBridge delivers at least once, so a retried event that is already
+	// stored is counted as a duplicate rather than written again.
+	err := e.Create(ctx, ddb, event.SourceEventBridge)
+	if err == nil {
+		err = flushStorage(ctx)
 	}
+	switch {
+	case errors.Is(err, database.ErrAlreadyExists):
+		logging.Sampled(ctx, "event already stored, skipping")
+		telemetry.RecordDuplicate(telemetry.SystemEventBridge, e.EventType.Label())
+		span.SetAttributes(attribute.Bool("event.duplicate", true))
+	case err != nil:
+		logging.Error(ctx, "failed to store event", logging.Err(err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		class := telemetry.ErrStorageOther
+		if database.IsThrottled(err) {
+			class = telemetry.ErrStorageThrottled
+		}
+		telemetry.RecordFailure(telemetry.SystemEventBridge, e.EventType.Label(), class)
+	default:
+		telemetry.ObserveSink(telemetry.SystemEventBridge, e.EventType.Label(), time.Since(received))
+		telemetry.RecordSuccess(telemetry.SystemEventBridge, e.EventType.Label(), e.Duration())
+		logging.Sampled(ctx, "event processed")
+	}
+
+	return nil
+}
 
+// flushStorage writes out what sinks such as the Parquet archive buffered
+// for this invocation. The environment may be frozen as soon as the handler
+// returns, and EventBridge takes a nil return as the event being stored.
+func flushStorage(ctx context.Context) error {
+	if f, ok := ddb.(database.Flusher); ok {
+		return f.Flush(ctx)
+	}
 	return nil
 }
 
+// shutdown writes out events still buffered by sinks such as the Parquet
+// archive and the metrics not yet pushed before the Lambda environment goes
+// away.
+func shutdown() {
+	if err := flushStorage(context.Background()); err != nil {
+		logging.Error(context.Background(), "failed to flush storage on shutdown", logging.Err(err))
+	}
+	metrics.Stop()
+	tracing.Shutdown(context.Background())
+}
+
 func main() {
-	lambda.Start(handler)
+	setup()
+	lambda.StartWithOptions(handler, lambda.WithEnableSIGTERM(shutdown))
 }
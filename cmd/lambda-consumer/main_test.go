@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Babatunde13/event-pipeline/internal/database"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/telemetry"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	mem := database.NewMemory()
+	if err := event.CreateTables(ctx, mem); err != nil {
+		t.Fatal(err)
+	}
+	ddb, metrics = mem, telemetry.NewPusher("")
+
+	detail := func(e event.Event) json.RawMessage {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	view := event.Event{EventID: "e1", Timestamp: 1, EventType: event.ViewProduct, UserID: "u1"}
+	invalid := event.Event{EventID: "e2", Timestamp: 2, EventType: "unknown", UserID: "u1"}
+	deliveries := []events.EventBridgeEvent{
+		{ID: "1", Detail: detail(view)},
+		{ID: "2", Detail: detail(view)}, // redelivered
+		{ID: "3", Detail: detail(invalid)},
+		{ID: "4", Detail: json.RawMessage(`"not an event"`)},
+	}
+	for _, d := range deliveries {
+		if err := handler(ctx, d); err != nil {
+			t.Fatalf("delivery %s: %v", d.ID, err)
+		}
+	}
+
+	var stored []event.Event
+	if _, err := mem.Scan(ctx, "events", database.Scan{}, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].EventID != "e1" || stored[0].Source != event.SourceEventBridge {
+		t.Fatalf("stored %+v, want e1 once from eventbridge", stored)
+	}
+}
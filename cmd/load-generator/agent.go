@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/joho/godotenv"
+)
+
+// agentStatus is what an agent answers on GET /status. Time lets the
+// coordinator estimate how far the agent's clock is from its own.
+type agentStatus struct {
+	Hostname string    `json:"hostname"`
+	Busy     bool      `json:"busy"`
+	Time     time.Time `json:"time"`
+}
+
+// agentRun is the body of POST /run: the agent's share of the load and
+// when to start sending it, by the agent's clock.
+type agentRun struct {
+	Config  RunConfig `json:"config"`
+	StartAt time.Time `json:"start_at"`
+}
+
+// agent runs the load a coordinator sends it, one run at a time.
+type agent struct {
+	hostname string
+	token    string
+	busy     atomic.Bool
+}
+
+// runAgent serves the agent API on -listen until it fails. A run lasts as
+// long as the request that started it.
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:7070", "Address to serve the coordinator on; use :7070 to accept coordinators on other machines")
+	token := fs.String("token", "", "Token coordinators must send; defaults to AGENT_TOKEN")
+	certFile := fs.String("tls_cert", "", "Certificate to serve HTTPS with, which coordinators on other machines require")
+	keyFile := fs.String("tls_key", "", "Private key of -tls_cert")
+	fs.Parse(args)
+	if (*certFile == "") != (*keyFile == "") {
+		return fmt.Errorf("agent: -tls_cert and -tls_key go together")
+	}
+	godotenv.Load()
+	if *token == "" {
+		*token = os.Getenv("AGENT_TOKEN")
+	}
+	if *token == "" {
+		return fmt.Errorf("agent: set a token with -token or AGENT_TOKEN")
+	}
+
+	a := &agent{token: *token}
+	a.hostname, _ = os.Hostname()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", a.authorized(a.status))
+	mux.HandleFunc("POST /run", a.authorized(a.run))
+	logging.Info(context.Background(), "agent listening", "addr", *listen, "tls", *certFile != "")
+	if *certFile != "" {
+		return http.ListenAndServeTLS(*listen, *certFile, *keyFile, mux)
+	}
+	return http.ListenAndServe(*listen, mux)
+}
+
+// authorized rejects requests that do not carry the agent's token as a
+// bearer token.
+func (a *agent) authorized(h http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + a.token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (a *agent) status(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, agentStatus{Hostname: a.hostname, Busy: a.busy.Load(), Time: time.Now()})
+}
+
+func (a *agent) run(w http.ResponseWriter, r *http.Request) {
+	var req agentRun
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid run: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := req.Config
+	if err := validateAgentRun(&cfg, req.StartAt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.busy.CompareAndSwap(false, true) {
+		http.Error(w, "a run is already in progress", http.StatusConflict)
+		return
+	}
+	defer a.busy.Store(false)
+
+	// The run stops if the coordinator goes away.
+	report, err := Run(r.Context(), cfg, req.StartAt)
+	if err != nil {
+		logging.Error(r.Context(), "agent run failed", logging.Err(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logging.Info(r.Context(), "agent run finished", "sent", report.Totals.Sent, "ok", report.Totals.OK)
+	respondJSON(w, http.StatusOK, report)
+}
+
+// validateAgentRun checks a run sent by a coordinator, which the agent
+// cannot ask about, and fills in BASE_URL from the agent's environment
+// when the coordinator had none. Target headers are used as sent: the
+// coordinator has already expanded them.
+func validateAgentRun(cfg *RunConfig, start time.Time) error {
+	switch {
+	case cfg.Scenario == nil:
+		return fmt.Errorf("no scenario")
+	case cfg.Mode != ModeOpen && cfg.Mode != ModeClosed:
+		return fmt.Errorf("invalid mode %q", cfg.Mode)
+	case cfg.Transport != TransportHTTP && cfg.Transport != TransportDirect:
+		return fmt.Errorf("invalid transport %q", cfg.Transport)
+	case cfg.Concurrency < 1:
+		return fmt.Errorf("concurrency must be at least 1")
+	case cfg.Record != "" || cfg.Replay != "":
+		return fmt.Errorf("agents cannot record or replay")
+	case time.Until(start) < 0:
+		return fmt.Errorf("start time %s has passed", start.Format(time.RFC3339Nano))
+	}
+	if err := cfg.ValidateTargets(); err != nil {
+		return err
+	}
+	names := cfg.TargetNames()
+	for _, name := range cfg.Selected {
+		if !contains(names, name) {
+			return fmt.Errorf("unknown target %q", name)
+		}
+	}
+	if err := cfg.Scenario.Validate(names); err != nil {
+		return err
+	}
+	if err := cfg.Scenario.ValidateSelected(cfg.Selected); err != nil {
+		return err
+	}
+	if err := cfg.Journey.Validate(); err != nil {
+		return err
+	}
+	if cfg.Transport == TransportHTTP && cfg.BaseURL == "" {
+		if cfg.BaseURL = os.Getenv("BASE_URL"); cfg.BaseURL == "" {
+			return fmt.Errorf("no base URL from the coordinator and BASE_URL is not set")
+		}
+	}
+	return nil
+}
+
+func respondJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runCompare prints how the report at args[1] differs from the one at
+// args[0]: throughput, failures and latency, overall and per target.
+func runCompare(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: load-generator compare <base.json> <other.json>")
+	}
+	a, err := ReadReport(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := ReadReport(args[1])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+	fmt.Fprintf(w, "metric\t%s\t%s\tdelta\tchange\t\n", args[0], args[1])
+
+	fmt.Fprintln(w, "== all targets\t\t\t\t\t")
+	compareCount(w, "duration_s", a.Totals.DurationS, b.Totals.DurationS)
+	compareCount(w, "achieved_eps", a.Totals.AchievedEPS, b.Totals.AchievedEPS)
+	compareCount(w, "sent", float64(a.Totals.Sent), float64(b.Totals.Sent))
+	compareCount(w, "errors", float64(a.Totals.Errors), float64(b.Totals.Errors))
+	compareCount(w, "error_rate_%", rate(a.Totals.Errors, a.Totals.Sent), rate(b.Totals.Errors, b.Totals.Sent))
+	compareCount(w, "late", float64(a.Totals.Late), float64(b.Totals.Late))
+	compareCount(w, "dropped", float64(a.Totals.Dropped), float64(b.Totals.Dropped))
+	compareCount(w, "bytes_sent_per_event", perEvent(a.Totals.BytesSent, a.Totals.Sent), perEvent(b.Totals.BytesSent, b.Totals.Sent))
+	compareCount(w, "bytes_received_per_event", perEvent(a.Totals.BytesReceived, a.Totals.Sent), perEvent(b.Totals.BytesReceived, b.Totals.Sent))
+	compareLatency(w, "latency", a.Latency, b.Latency)
+	if payloadNote(a) != "" || payloadNote(b) != "" {
+		fmt.Fprintln(w, "bytes of direct Kafka targets are payload only; compare bytes per event with care\t\t\t\t\t")
+	}
+
+	for _, ta := range a.Targets {
+		for _, tb := range b.Targets {
+			if ta.Name != tb.Name {
+				continue
+			}
+			fmt.Fprintf(w, "== %s\t\t\t\t\t\n", ta.Name)
+			compareCount(w, "sent", float64(ta.Sent), float64(tb.Sent))
+			compareCount(w, "errors", float64(ta.Errors), float64(tb.Errors))
+			compareCount(w, "error_rate_%", rate(ta.Errors, ta.Sent), rate(tb.Errors, tb.Sent))
+			compareCount(w, "late", float64(ta.Late), float64(tb.Late))
+			compareCount(w, "dropped", float64(ta.Dropped), float64(tb.Dropped))
+			compareLatency(w, "latency", ta.Latency, tb.Latency)
+			compareLatency(w, "service", ta.ServiceTime, tb.ServiceTime)
+		}
+	}
+	return nil
+}
+
+func compareCount(w *tabwriter.Writer, name string, a, b float64) {
+	fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%+.2f\t%s\t\n", name, a, b, b-a, change(a, b))
+}
+
+func compareLatency(w *tabwriter.Writer, name string, a, b Latency) {
+	rows := []struct {
+		q    string
+		a, b int64
+	}{
+		{"p50", a.P50, b.P50},
+		{"p90", a.P90, b.P90},
+		{"p95", a.P95, b.P95},
+		{"p99", a.P99, b.P99},
+		{"p99.9", a.P999, b.P999},
+		{"max", a.Max, b.Max},
+	}
+	for _, r := range rows {
+		delta := usString(abs(r.b - r.a))
+		if r.b < r.a {
+			delta = "-" + delta
+		} else {
+			delta = "+" + delta
+		}
+		fmt.Fprintf(w, "%s_%s\t%s\t%s\t%s\t%s\t\n", name, r.q, usString(r.a), usString(r.b), delta, change(float64(r.a), float64(r.b)))
+	}
+}
+
+func change(a, b float64) string {
+	if a == 0 {
+		if b == 0 {
+			return "0%"
+		}
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(b-a)/a)
+}
+
+func rate(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+func perEvent(bytes, events int64) float64 {
+	if events == 0 {
+		return 0
+	}
+	return float64(bytes) / float64(events)
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+)
+
+// AgentReport is how one agent of a distributed run did.
+type AgentReport struct {
+	Address       string  `json:"address"`
+	Hostname      string  `json:"hostname,omitempty"`
+	ClockOffsetMs float64 `json:"clock_offset_ms"` // the agent's clock minus the coordinator's
+	Share         float64 `json:"share"`           // of the scenario's rate
+	Totals        Totals  `json:"totals"`
+	Latency       Latency `json:"latency"`
+	Error         string  `json:"error,omitempty"`
+}
+
+func (a AgentReport) Print() {
+	if a.Error != "" {
+		fmt.Printf("  %-24s failed: %s\n", a.Address, a.Error)
+		return
+	}
+	fmt.Printf("  %-24s %d sent, %d errors, %.2f EPS, p99=%s, clock offset %.1fms\n",
+		a.Address, a.Totals.Sent, a.Totals.Errors, a.Totals.AchievedEPS, usString(a.Latency.P99), a.ClockOffsetMs)
+}
+
+// agentClient has no timeout, since a run request lasts as long as the run.
+var agentClient = &http.Client{}
+
+// trustAgentCA makes agentClient accept agent certificates signed by the CA
+// in the PEM file at path, besides the system ones.
+func trustAgentCA(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates in %s", path)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	agentClient.Transport = transport
+	return nil
+}
+
+// Coordinate splits the load cfg describes evenly between agents, starts
+// them together startDelay from now and merges their reports into one.
+// Requests to the agents carry token, and target headers are expanded here
+// before they are sent, so agents on other machines must be reached over
+// HTTPS.
+// Each agent draws its events from its own seed, so no two send the same
+// event. Agents that fail are listed in the report; the run fails only when
+// all of them do.
+func Coordinate(ctx context.Context, cfg RunConfig, agents []string, token string, startDelay time.Duration) (*Report, error) {
+	n := len(agents)
+	summaries := make([]AgentReport, n)
+	offsets := make([]time.Duration, n)
+	for i, addr := range agents {
+		addr = strings.TrimSpace(addr)
+		if err := checkAgentURL(addr); err != nil {
+			return nil, fmt.Errorf("agent %s: %w", addr, err)
+		}
+		status, offset, err := probeAgent(ctx, addr, token)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", addr, err)
+		}
+		if status.Busy {
+			return nil, fmt.Errorf("agent %s is busy with another run", addr)
+		}
+		agents[i], offsets[i] = addr, offset
+		summaries[i] = AgentReport{Address: addr, Hostname: status.Hostname, ClockOffsetMs: msFloat(offset), Share: 1 / float64(n)}
+	}
+
+	start := time.Now().Add(startDelay)
+	logging.Info(ctx, "starting agents", "agents", n, "start", start.Format(time.RFC3339Nano))
+	reports := make([]*Report, n)
+	targets := expandTargetHeaders(cfg.TargetConfigs)
+	wg := &sync.WaitGroup{}
+	for i, addr := range agents {
+		part := cfg
+		part.TargetConfigs = targets
+		part.Scenario = cfg.Scenario.Scaled(1 / float64(n))
+		part.Seed = cfg.Seed + int64(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The agent starts at the same instant by its own clock.
+			r, err := runOnAgent(ctx, addr, token, agentRun{Config: part, StartAt: start.Add(offsets[i])})
+			if err != nil {
+				logging.Error(ctx, "agent run failed", "agent", addr, logging.Err(err))
+				summaries[i].Error = err.Error()
+				return
+			}
+			r.StartedAt = r.StartedAt.Add(-offsets[i])
+			r.FinishedAt = r.FinishedAt.Add(-offsets[i])
+			summaries[i].Totals, summaries[i].Latency = r.Totals, r.Latency
+			reports[i] = r
+		}()
+	}
+	wg.Wait()
+
+	var done []*Report
+	for _, r := range reports {
+		if r != nil {
+			done = append(done, r)
+		}
+	}
+	if len(done) == 0 {
+		return nil, fmt.Errorf("every agent failed")
+	}
+	cfg.Agents = agents
+	merged := mergeReports(cfg, done)
+	merged.Agents = summaries
+	return merged, nil
+}
+
+// probeAgent fetches the status of the agent at addr and estimates its
+// clock offset from the middle of the round trip.
+func probeAgent(ctx context.Context, addr, token string) (*agentStatus, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agentURL(addr, "/status"), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	sent := time.Now()
+	resp, err := agentClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	received := time.Now()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("status: %s", resp.Status)
+	}
+	var status agentStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, 0, fmt.Errorf("status: %w", err)
+	}
+	mid := sent.Add(received.Sub(sent) / 2)
+	return &status, status.Time.Sub(mid.Round(0)), nil
+}
+
+func runOnAgent(ctx context.Context, addr, token string, run agentRun) (*Report, error) {
+	body, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agentURL(addr, "/run"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := agentClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var r Report
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("report: %w", err)
+	}
+	return &r, nil
+}
+
+// checkAgentURL refuses plain HTTP to agents on other machines, since
+// every request carries the agent token and the plan carries expanded
+// target headers.
+func checkAgentURL(addr string) error {
+	u, err := url.Parse(agentURL(addr, ""))
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.Scheme == "https":
+		return nil
+	case u.Scheme != "http":
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	case isLoopback(u.Hostname()):
+		return nil
+	}
+	return fmt.Errorf("not on this machine, so it must be reached over https://%s to keep the token and headers private", u.Host)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func agentURL(addr, path string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/") + path
+}
+
+func msFloat(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// mergeReports combines the reports of the agents of one run. Counts add
+// up and histograms are merged, so percentiles are exact over the whole
+// run; the percentiles of each second are those of the slowest agent.
+func mergeReports(cfg RunConfig, reports []*Report) *Report {
+	out := &Report{
+		Version:     reportVersion,
+		Command:     os.Args,
+		StartedAt:   reports[0].StartedAt,
+		FinishedAt:  reports[0].FinishedAt,
+		Environment: environment(),
+	}
+	cfg.Targets = map[string]string{}
+	targets := map[string]*TargetReport{}
+	deliveries := map[string]*DeliveryReport{}
+	for _, r := range reports {
+		if r.StartedAt.Before(out.StartedAt) {
+			out.StartedAt = r.StartedAt
+		}
+		if r.FinishedAt.After(out.FinishedAt) {
+			out.FinishedAt = r.FinishedAt
+		}
+		if cfg.Queue == 0 {
+			cfg.Queue = r.Config.Queue
+		}
+		for name, url := range r.Config.Targets {
+			cfg.Targets[name] = url
+		}
+		out.Funnel.Sessions += r.Funnel.Sessions
+		out.Funnel.Views += r.Funnel.Views
+		out.Funnel.Carts += r.Funnel.Carts
+		out.Funnel.Checkouts += r.Funnel.Checkouts
+		out.Funnel.DropOffs += r.Funnel.DropOffs
+
+		for _, t := range r.Targets {
+			m, ok := targets[t.Name]
+			if !ok {
+				m = &TargetReport{Name: t.Name, URL: t.URL, Statuses: map[string]int64{}, ErrorsByType: map[string]int64{}}
+				targets[t.Name] = m
+			}
+			mergeTarget(m, t)
+		}
+		if v := r.Verification; v != nil {
+			if out.Verification == nil {
+				out.Verification = &Verification{GraceS: v.GraceS}
+			}
+			for _, d := range v.Targets {
+				m, ok := deliveries[d.Name]
+				if !ok {
+					m = &DeliveryReport{Name: d.Name}
+					deliveries[d.Name] = m
+				}
+				mergeDelivery(m, d)
+			}
+		}
+	}
+	out.Config = cfg
+
+	all := newHistogram()
+	for _, name := range cfg.TargetNames() {
+		t, ok := targets[name]
+		if !ok {
+			continue
+		}
+		h := t.Histogram.HDR()
+		all.Merge(h)
+		t.Latency, t.Histogram = summarise(h), exportHistogram(h)
+		service := t.ServiceHistogram.HDR()
+		t.ServiceTime, t.ServiceHistogram = summarise(service), exportHistogram(service)
+		out.Targets = append(out.Targets, *t)
+
+		out.Totals.Sent += t.Sent
+		out.Totals.OK += t.OK
+		out.Totals.Errors += t.Errors
+		out.Totals.Late += t.Late
+		out.Totals.Dropped += t.Dropped
+		out.Totals.BytesSent += t.BytesSent
+		out.Totals.BytesReceived += t.BytesReceived
+	}
+	out.Latency = summarise(all)
+	out.Totals.DurationS = out.FinishedAt.Sub(out.StartedAt).Seconds()
+	out.Totals.AchievedEPS = float64(out.Totals.Sent) / out.Totals.DurationS
+
+	if v := out.Verification; v != nil {
+		total := &DeliveryReport{Name: "all"}
+		for _, name := range cfg.TargetNames() {
+			d, ok := deliveries[name]
+			if !ok {
+				continue
+			}
+			mergeDelivery(total, *d)
+			h := d.Histogram.HDR()
+			d.EndToEnd, d.Histogram = summarise(h), exportHistogram(h)
+			v.Targets = append(v.Targets, *d)
+		}
+		h := total.Histogram.HDR()
+		total.EndToEnd, total.Histogram = summarise(h), nil
+		total.MissingSample = nil
+		v.Total = *total
+	}
+	return out
+}
+
+// mergeTarget adds t to m. Histogram buckets are appended and rebuilt by
+// the caller once every agent is in.
+func mergeTarget(m *TargetReport, t TargetReport) {
+	m.Sent += t.Sent
+	m.OK += t.OK
+	m.Errors += t.Errors
+	m.Late += t.Late
+	m.Dropped += t.Dropped
+	m.BytesSent += t.BytesSent
+	m.BytesReceived += t.BytesReceived
+	m.BytesPayloadOnly = m.BytesPayloadOnly || t.BytesPayloadOnly
+	for k, v := range t.Statuses {
+		m.Statuses[k] += v
+	}
+	for k, v := range t.ErrorsByType {
+		m.ErrorsByType[k] += v
+	}
+	m.Histogram = append(m.Histogram, t.Histogram...)
+	m.ServiceHistogram = append(m.ServiceHistogram, t.ServiceHistogram...)
+	m.Series = mergeSeries(m.Series, t.Series)
+}
+
+func mergeDelivery(m *DeliveryReport, d DeliveryReport) {
+	m.Accepted += d.Accepted
+	m.Delivered += d.Delivered
+	m.Missing += d.Missing
+	m.Duplicated += d.Duplicated
+	m.Unexpected += d.Unexpected
+	m.ReadErrors += d.ReadErrors
+	m.Histogram = append(m.Histogram, d.Histogram...)
+	for _, id := range d.MissingSample {
+		if len(m.MissingSample) < missingSample {
+			m.MissingSample = append(m.MissingSample, id)
+		}
+	}
+}
+
+// mergeSeries adds up two time series second by second.
+func mergeSeries(a, b []Second) []Second {
+	bySecond := map[int]Second{}
+	for _, series := range [][]Second{a, b} {
+		for _, s := range series {
+			m := bySecond[s.Elapsed]
+			m.Elapsed = s.Elapsed
+			m.Sent += s.Sent
+			m.OK += s.OK
+			m.Errors += s.Errors
+			m.Late += s.Late
+			m.Dropped += s.Dropped
+			m.BytesSent += s.BytesSent
+			m.BytesReceived += s.BytesReceived
+			m.P50 = max(m.P50, s.P50)
+			m.P90 = max(m.P90, s.P90)
+			m.P99 = max(m.P99, s.P99)
+			m.Max = max(m.Max, s.Max)
+			bySecond[s.Elapsed] = m
+		}
+	}
+	out := make([]Second, 0, len(bySecond))
+	for _, s := range bySecond {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Elapsed < out[j].Elapsed })
+	return out
+}
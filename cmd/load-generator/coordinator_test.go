@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeSeries(t *testing.T) {
+	a := []Second{
+		{Elapsed: 0, Sent: 10, OK: 9, Errors: 1, BytesSent: 100, P50: 10, P99: 50, Max: 80},
+		{Elapsed: 1, Sent: 10, OK: 10, BytesSent: 100, P50: 12, P99: 40, Max: 60},
+	}
+	b := []Second{
+		{Elapsed: 2, Sent: 5, OK: 5, BytesSent: 50, P50: 20, P99: 30, Max: 30},
+		{Elapsed: 1, Sent: 7, OK: 6, Late: 1, Dropped: 2, BytesSent: 70, P50: 15, P99: 35, Max: 90},
+	}
+	want := []Second{
+		{Elapsed: 0, Sent: 10, OK: 9, Errors: 1, BytesSent: 100, P50: 10, P99: 50, Max: 80},
+		{Elapsed: 1, Sent: 17, OK: 16, Late: 1, Dropped: 2, BytesSent: 170, P50: 15, P99: 40, Max: 90},
+		{Elapsed: 2, Sent: 5, OK: 5, BytesSent: 50, P50: 20, P99: 30, Max: 30},
+	}
+	if got := mergeSeries(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	agent := func(offset time.Duration, sent, ok int64, latency int64, accepted, delivered int64) *Report {
+		return &Report{
+			StartedAt:  start.Add(offset),
+			FinishedAt: start.Add(offset + 10*time.Second),
+			Config:     RunConfig{Queue: 100, Targets: map[string]string{"kafka": "http://producer/kafka"}},
+			Funnel:     Funnel{Sessions: 2, Views: 10},
+			Targets: []TargetReport{{
+				Name:      "kafka",
+				Sent:      sent,
+				OK:        ok,
+				Errors:    sent - ok,
+				BytesSent: sent * 100,
+				Statuses:  map[string]int64{"202": ok},
+				Histogram: Histogram{{Value: latency, Count: sent}},
+				Series:    []Second{{Elapsed: 0, Sent: sent, OK: ok}},
+			}},
+			Verification: &Verification{GraceS: 5, Targets: []DeliveryReport{{
+				Name:      "kafka",
+				Accepted:  accepted,
+				Delivered: delivered,
+				Missing:   accepted - delivered,
+				Histogram: Histogram{{Value: latency * 10, Count: delivered}},
+			}}},
+		}
+	}
+	reports := []*Report{
+		agent(0, 100, 90, 1000, 90, 90),
+		agent(2*time.Second, 300, 300, 3000, 300, 298),
+	}
+
+	got := mergeReports(RunConfig{}, reports)
+
+	if !got.StartedAt.Equal(start) || !got.FinishedAt.Equal(start.Add(12*time.Second)) {
+		t.Errorf("run from %v to %v, want the first start and the last finish", got.StartedAt, got.FinishedAt)
+	}
+	if got.Config.Queue != 100 || got.Config.Targets["kafka"] != "http://producer/kafka" {
+		t.Errorf("config %+v, want the agents' queue and targets", got.Config)
+	}
+	if got.Funnel.Sessions != 4 || got.Funnel.Views != 20 {
+		t.Errorf("funnel %+v, want the sum of the agents'", got.Funnel)
+	}
+
+	wantTotals := Totals{DurationS: 12, Sent: 400, OK: 390, Errors: 10, BytesSent: 40000, AchievedEPS: 400.0 / 12}
+	if got.Totals != wantTotals {
+		t.Errorf("totals %+v, want %+v", got.Totals, wantTotals)
+	}
+	if len(got.Targets) != 1 {
+		t.Fatalf("%d targets, want kafka alone", len(got.Targets))
+	}
+	kafka := got.Targets[0]
+	if kafka.Statuses["202"] != 390 || kafka.Latency.Count != 400 {
+		t.Errorf("kafka has %d 202s and %d latencies, want 390 and 400", kafka.Statuses["202"], kafka.Latency.Count)
+	}
+	// A quarter of the events took 1ms and the rest 3ms.
+	if p := got.Latency.P50; p < 2990 || p > 3010 {
+		t.Errorf("p50 %dµs, want 3000µs from the merged histograms", p)
+	}
+	if s := kafka.Series; len(s) != 1 || s[0].Sent != 400 || s[0].OK != 390 {
+		t.Errorf("series %+v, want one second with 400 sent and 390 ok", s)
+	}
+
+	v := got.Verification
+	if v == nil || len(v.Targets) != 1 {
+		t.Fatalf("verification %+v, want kafka alone", v)
+	}
+	if v.Total.Accepted != 390 || v.Total.Delivered != 388 || v.Total.Missing != 2 || v.Total.EndToEnd.Count != 388 {
+		t.Errorf("delivery %+v, want 390 accepted, 388 delivered and 2 missing", v.Total)
+	}
+}
+
+func TestCheckAgentURL(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"localhost:7070", false},
+		{"127.0.0.1:7070", false},
+		{"[::1]:7070", false},
+		{"http://127.0.0.1:7070", false},
+		{"https://agent1.example.com:7070", false},
+		{"agent1.example.com:7070", true},
+		{"http://10.0.0.5:7070", true},
+		{"ftp://127.0.0.1:7070", true},
+	}
+	for _, tt := range tests {
+		if err := checkAgentURL(tt.addr); (err != nil) != tt.wantErr {
+			t.Errorf("checkAgentURL(%q) = %v, want an error: %v", tt.addr, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// The HTML report is a single file with inline SVG charts, so it can be
+// attached to a thesis run without anything else.
+
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b"}
+
+// chartSeries is one line of a chart.
+type chartSeries struct {
+	Name   string
+	Values []float64
+}
+
+const (
+	chartW, chartH = 920, 300
+	padL, padR     = 70, 20
+	padT, padB     = 30, 50
+)
+
+// lineChart draws series against shared x labels as an SVG line chart.
+func lineChart(title, unit string, labels []string, series []chartSeries) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" class="chart" role="img"><title>%s</title>`, chartW, chartH, template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<text x="%d" y="18" class="title">%s</text>`, padL, template.HTMLEscapeString(title))
+
+	top := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			top = math.Max(top, v)
+		}
+	}
+	top = niceCeil(top)
+	plotW, plotH := float64(chartW-padL-padR), float64(chartH-padT-padB)
+	x := func(i int) float64 {
+		if len(labels) < 2 {
+			return padL + plotW/2
+		}
+		return padL + plotW*float64(i)/float64(len(labels)-1)
+	}
+	y := func(v float64) float64 { return padT + plotH*(1-v/top) }
+
+	// Horizontal grid with y labels.
+	for i := 0; i <= 4; i++ {
+		v := top * float64(i) / 4
+		fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" class="grid"/>`, padL, chartW-padR, y(v), y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" class="axis" text-anchor="end">%s</text>`, padL-6, y(v)+4, formatValue(v, unit))
+	}
+	// At most a dozen x labels.
+	step := max(1, len(labels)/12)
+	for i := 0; i < len(labels); i += step {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" class="axis" text-anchor="middle">%s</text>`, x(i), chartH-padB+18, template.HTMLEscapeString(labels[i]))
+	}
+
+	for n, s := range series {
+		color := palette[n%len(palette)]
+		points := make([]string, len(s.Values))
+		for i, v := range s.Values {
+			points[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(v))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, color, strings.Join(points, " "))
+		if len(s.Values) == 1 {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`, x(0), y(s.Values[0]), color)
+		}
+		lx := padL + n*180
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="12" height="3" fill="%s"/>`, lx, chartH-14, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" class="axis">%s</text>`, lx+16, chartH-10, template.HTMLEscapeString(s.Name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+func formatValue(v float64, unit string) string {
+	if unit == "us" {
+		return usString(int64(v))
+	}
+	if v >= 1000 {
+		return fmt.Sprintf("%.0fk", v/1000)
+	}
+	return fmt.Sprintf("%g", math.Round(v*10)/10)
+}
+
+// reportQuantiles are the points of the latency by percentile chart.
+var reportQuantiles = []float64{50, 75, 90, 95, 99, 99.9, 99.99, 100}
+
+type htmlView struct {
+	*Report
+	Charts []template.HTML
+}
+
+func writeHTML(f *os.File, r *Report) error {
+	view := htmlView{Report: r}
+
+	var labels []string
+	if len(r.Targets) > 0 {
+		for _, sec := range r.Targets[0].Series {
+			labels = append(labels, fmt.Sprintf("%ds", sec.Elapsed))
+		}
+	}
+	var sent, failed, p50, p99 []chartSeries
+	for _, t := range r.Targets {
+		var s, e, q50, q99 []float64
+		for _, sec := range t.Series {
+			s = append(s, float64(sec.Sent))
+			e = append(e, float64(sec.Errors+sec.Late+sec.Dropped))
+			q50 = append(q50, float64(sec.P50))
+			q99 = append(q99, float64(sec.P99))
+		}
+		sent = append(sent, chartSeries{t.Name, s})
+		failed = append(failed, chartSeries{t.Name, e})
+		p50 = append(p50, chartSeries{t.Name, q50})
+		p99 = append(p99, chartSeries{t.Name, q99})
+	}
+	view.Charts = append(view.Charts,
+		lineChart("Requests per second", "", labels, sent),
+		lineChart("Errors, late and dropped sends per second", "", labels, failed),
+		lineChart("p50 latency per second", "us", labels, p50),
+		lineChart("p99 latency per second", "us", labels, p99),
+	)
+
+	var qLabels []string
+	for _, q := range reportQuantiles {
+		qLabels = append(qLabels, fmt.Sprintf("p%g", q))
+	}
+	var byQuantile []chartSeries
+	for _, t := range r.Targets {
+		h := t.Histogram.HDR()
+		var vals []float64
+		for _, q := range reportQuantiles {
+			vals = append(vals, float64(h.ValueAtQuantile(q)))
+		}
+		byQuantile = append(byQuantile, chartSeries{t.Name, vals})
+	}
+	view.Charts = append(view.Charts, lineChart("Latency by percentile", "us", qLabels, byQuantile))
+
+	return reportTemplate.Execute(f, view)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"us":  usString,
+	"usf": func(us float64) string { return usString(int64(us)) },
+	"dur": func(d Duration) string { return time.Duration(d).String() },
+	"secs": func(s float64) string {
+		return (time.Duration(s * float64(time.Second))).Round(time.Millisecond).String()
+	},
+	"pct":   percent,
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Load test {{.Config.Scenario.Name}} {{.StartedAt.Format "2006-01-02 15:04"}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 960px; color: #222; }
+h1 { font-size: 1.5em; } h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+table { border-collapse: collapse; margin: 0.5em 0; font-size: 0.9em; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #eee; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+code { background: #f4f4f4; padding: 1px 4px; }
+.chart { width: 100%; height: auto; margin: 1em 0; }
+.chart .title { font-size: 14px; font-weight: bold; }
+.chart .axis { font-size: 11px; fill: #555; }
+.chart .grid { stroke: #e5e5e5; }
+</style>
+</head>
+<body>
+<h1>Load test: {{.Config.Scenario.Name}}</h1>
+<p>{{.StartedAt.Format "2006-01-02 15:04:05 MST"}} for {{secs .Totals.DurationS}}, {{.Config.Mode}} loop, {{.Config.Concurrency}} workers.</p>
+<p><code>{{range .Command}}{{.}} {{end}}</code></p>
+
+<h2>Totals</h2>
+<table>
+<tr><th></th><th>Sent</th><th>OK</th><th>Errors</th><th>Late</th><th>Dropped</th><th>Bytes sent</th><th>Bytes received</th><th>Achieved EPS</th></tr>
+<tr><td>all</td><td>{{.Totals.Sent}}</td><td>{{.Totals.OK}}</td><td>{{.Totals.Errors}} ({{pct .Totals.Errors .Totals.Sent}})</td><td>{{.Totals.Late}}</td><td>{{.Totals.Dropped}}</td><td>{{bytes .Totals.BytesSent}}</td><td>{{bytes .Totals.BytesReceived}}</td><td>{{printf "%.2f" .Totals.AchievedEPS}}</td></tr>
+{{range .Targets}}<tr><td>{{.Name}}</td><td>{{.Sent}}</td><td>{{.OK}}</td><td>{{.Errors}} ({{pct .Errors .Sent}})</td><td>{{.Late}}</td><td>{{.Dropped}}</td><td>{{bytes .BytesSent}}{{if .BytesPayloadOnly}} (payload only){{end}}</td><td>{{if .BytesPayloadOnly}}not measured{{else}}{{bytes .BytesReceived}}{{end}}</td><td></td></tr>
+{{end}}</table>
+
+<h2>Latency</h2>
+<table>
+<tr><th></th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>p99.9</th><th>max</th><th>mean</th></tr>
+<tr><td>all</td><td>{{us .Latency.P50}}</td><td>{{us .Latency.P90}}</td><td>{{us .Latency.P95}}</td><td>{{us .Latency.P99}}</td><td>{{us .Latency.P999}}</td><td>{{us .Latency.Max}}</td><td>{{usf .Latency.Mean}}</td></tr>
+{{range .Targets}}<tr><td>{{.Name}}</td><td>{{us .Latency.P50}}</td><td>{{us .Latency.P90}}</td><td>{{us .Latency.P95}}</td><td>{{us .Latency.P99}}</td><td>{{us .Latency.P999}}</td><td>{{us .Latency.Max}}</td><td>{{usf .Latency.Mean}}</td></tr>
+<tr><td>{{.Name}} service time</td><td>{{us .ServiceTime.P50}}</td><td>{{us .ServiceTime.P90}}</td><td>{{us .ServiceTime.P95}}</td><td>{{us .ServiceTime.P99}}</td><td>{{us .ServiceTime.P999}}</td><td>{{us .ServiceTime.Max}}</td><td>{{usf .ServiceTime.Mean}}</td></tr>
+{{end}}</table>
+
+<h2>Charts</h2>
+{{range .Charts}}{{.}}
+{{end}}
+
+<h2>Status codes and errors</h2>
+<table>
+<tr><th>Target</th><th>Status codes</th><th>Errors by type</th></tr>
+{{range .Targets}}<tr><td>{{.Name}}</td><td>{{range $k, $v := .Statuses}}{{$k}}={{$v}} {{end}}</td><td>{{range $k, $v := .ErrorsByType}}{{$k}}={{$v}} {{else}}none{{end}}</td></tr>
+{{end}}</table>
+
+{{with .Verification}}<h2>Delivery</h2>
+<p>Every event sent was looked up in the sink {{secs .GraceS}} after the run. End to end latency runs from the event timestamp to when it was stored.</p>
+<table>
+<tr><th></th><th>Accepted</th><th>Delivered</th><th>Missing</th><th>Duplicated</th><th>Unexpected</th><th>Read errors</th><th>p50</th><th>p99</th><th>max</th></tr>
+{{range .Targets}}<tr><td>{{.Name}}</td><td>{{.Accepted}}</td><td>{{.Delivered}} ({{pct .Delivered .Accepted}})</td><td>{{.Missing}}</td><td>{{.Duplicated}}</td><td>{{.Unexpected}}</td><td>{{.ReadErrors}}</td><td>{{us .EndToEnd.P50}}</td><td>{{us .EndToEnd.P99}}</td><td>{{us .EndToEnd.Max}}</td></tr>
+{{end}}{{with .Total}}<tr><td>all</td><td>{{.Accepted}}</td><td>{{.Delivered}} ({{pct .Delivered .Accepted}})</td><td>{{.Missing}}</td><td>{{.Duplicated}}</td><td>{{.Unexpected}}</td><td>{{.ReadErrors}}</td><td>{{us .EndToEnd.P50}}</td><td>{{us .EndToEnd.P99}}</td><td>{{us .EndToEnd.Max}}</td></tr>{{end}}
+</table>
+{{end}}
+
+{{if .Config.Replay}}<h2>Replay</h2>
+<p>Events replayed from <code>{{.Config.Replay}}</code> at {{.Config.ReplaySpeed}}x their recorded timing, generated with seed {{.Config.Seed}}.</p>
+{{else}}<h2>Journeys</h2>
+<p>Generated with seed {{.Config.Seed}}.</p>
+<table>
+<tr><th>Sessions</th><th>Views</th><th>Add to cart</th><th>Checkouts</th><th>Drop-offs</th><th>Users</th><th>Products</th><th>Zipf s</th></tr>
+<tr><td>{{.Funnel.Sessions}}</td><td>{{.Funnel.Views}}</td><td>{{.Funnel.Carts}}</td><td>{{.Funnel.Checkouts}}</td><td>{{.Funnel.DropOffs}}</td><td>{{.Config.Journey.Users}}</td><td>{{.Config.Journey.Products}}</td><td>{{.Config.Journey.ZipfS}}</td></tr>
+</table>
+{{end}}
+<h2>Scenario</h2>
+<table>
+<tr><th>Phase</th><th>Type</th><th>Duration</th><th>EPS</th><th>To EPS</th><th>Mix</th></tr>
+{{range .Config.Scenario.Phases}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{dur .Duration}}</td><td>{{.EPS}}</td><td>{{.ToEPS}}</td><td>{{range $k, $v := .Mix}}{{$k}}={{$v}} {{end}}</td></tr>
+{{end}}</table>
+{{with .Config.Scenario.Mix}}<p>Scenario mix: {{range $k, $v := .}}{{$k}}={{$v}} {{end}}</p>{{end}}
+
+{{with .Config.TargetConfigs}}<h2>Targets</h2>
+<table>
+<tr><th>Target</th><th>URL</th><th>Headers</th><th>Template</th><th>Batch</th><th>Event types</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.URL}}</td><td>{{range $k, $v := .Headers}}{{$k}} {{end}}</td><td>{{if .Template}}yes{{end}}</td><td>{{.Batch}}</td><td>{{range $k, $v := .EventTypes}}{{$k}}={{$v}} {{end}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{with .Agents}}<h2>Agents</h2>
+<table>
+<tr><th>Agent</th><th>Host</th><th>Share</th><th>Sent</th><th>Errors</th><th>Achieved EPS</th><th>p99</th><th>Clock offset</th></tr>
+{{range .}}<tr><td>{{.Address}}</td><td>{{.Hostname}}</td><td>{{printf "%.2f" .Share}}</td>{{if .Error}}<td colspan="5">failed: {{.Error}}</td>{{else}}<td>{{.Totals.Sent}}</td><td>{{.Totals.Errors}}</td><td>{{printf "%.2f" .Totals.AchievedEPS}}</td><td>{{us .Latency.P99}}</td><td>{{printf "%.1f" .ClockOffsetMs}}ms</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+
+<h2>Environment</h2>
+<table>
+<tr><td>Host</td><td>{{.Environment.Hostname}}</td></tr>
+<tr><td>Go</td><td>{{.Environment.GoVersion}} {{.Environment.OS}}/{{.Environment.Arch}}</td></tr>
+<tr><td>CPUs</td><td>{{.Environment.CPUs}}</td></tr>
+{{with .Environment.Revision}}<tr><td>Revision</td><td>{{.}}</td></tr>{{end}}
+{{range $name, $url := .Config.Targets}}<tr><td>{{$name}}</td><td>{{$url}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/google/uuid"
+)
+
+// JourneyConfig is the behaviour model events are drawn from: a population
+// of users browsing a catalogue in sessions, moving between event types
+// with the given probabilities.
+type JourneyConfig struct {
+	Users    int     `json:"users,omitempty" yaml:"users,omitempty"`
+	Products int     `json:"products,omitempty" yaml:"products,omitempty"`
+	Sessions int     `json:"sessions,omitempty" yaml:"sessions,omitempty"` // concurrent sessions per target
+	ZipfS    float64 `json:"zipf_s,omitempty" yaml:"zipf_s,omitempty"`     // product popularity skew, above 1
+
+	// What a user does after viewing a product and after adding one to the
+	// cart. Whatever probability is left over ends the session.
+	AfterView Transitions `json:"after_view,omitempty" yaml:"after_view,omitempty"`
+	AfterCart Transitions `json:"after_cart,omitempty" yaml:"after_cart,omitempty"`
+}
+
+// Transitions are the probabilities of the next event in a session.
+type Transitions struct {
+	View     float64 `json:"view_product,omitempty" yaml:"view_product,omitempty"`
+	Cart     float64 `json:"add_to_cart,omitempty" yaml:"add_to_cart,omitempty"`
+	Checkout float64 `json:"checkout,omitempty" yaml:"checkout,omitempty"`
+}
+
+// DefaultJourney is a storefront where most sessions browse a few products
+// and about one in ten checks out.
+func DefaultJourney() JourneyConfig {
+	return JourneyConfig{
+		Users:     10000,
+		Products:  500,
+		Sessions:  200,
+		ZipfS:     1.1,
+		AfterView: Transitions{View: 0.55, Cart: 0.25},
+		AfterCart: Transitions{View: 0.35, Checkout: 0.40},
+	}
+}
+
+// Merge returns c with the fields set in o replacing its own.
+func (c JourneyConfig) Merge(o *JourneyConfig) JourneyConfig {
+	if o == nil {
+		return c
+	}
+	if o.Users > 0 {
+		c.Users = o.Users
+	}
+	if o.Products > 0 {
+		c.Products = o.Products
+	}
+	if o.Sessions > 0 {
+		c.Sessions = o.Sessions
+	}
+	if o.ZipfS > 0 {
+		c.ZipfS = o.ZipfS
+	}
+	if o.AfterView != (Transitions{}) {
+		c.AfterView = o.AfterView
+	}
+	if o.AfterCart != (Transitions{}) {
+		c.AfterCart = o.AfterCart
+	}
+	return c
+}
+
+// Validate checks the population is not empty and the transitions are
+// probabilities.
+func (c JourneyConfig) Validate() error {
+	switch {
+	case c.Users < 1 || c.Products < 1 || c.Sessions < 1:
+		return fmt.Errorf("journey: users, products and sessions must be at least 1")
+	case c.ZipfS <= 1:
+		return fmt.Errorf("journey: zipf_s must be above 1")
+	}
+	for name, t := range map[string]Transitions{"after_view": c.AfterView, "after_cart": c.AfterCart} {
+		if t.View < 0 || t.Cart < 0 || t.Checkout < 0 || t.View+t.Cart+t.Checkout > 1 {
+			return fmt.Errorf("journey: %s probabilities must be between 0 and 1 and add up to at most 1", name)
+		}
+	}
+	return nil
+}
+
+// Funnel counts what the generated sessions did.
+type Funnel struct {
+	Sessions  int64 `json:"sessions"`
+	Views     int64 `json:"views"`
+	Carts     int64 `json:"carts"`
+	Checkouts int64 `json:"checkouts"`
+	DropOffs  int64 `json:"drop_offs"` // sessions that ended without a checkout
+}
+
+type cartItem struct {
+	product  int
+	quantity int
+}
+
+type session struct {
+	id      string
+	user    string
+	last    event.EventType
+	product int // last product viewed
+	cart    []cartItem
+}
+
+// Journeys generates events from sessions of simulated users. Sessions are
+// kept per target, so each pipeline sees whole journeys. The same seed
+// generates the same events in the same order for each target. Their IDs
+// also mix in a nonce drawn for every Journeys, so that two runs with the
+// same seed do not overwrite each other's events in the sink. It is not
+// safe for concurrent use.
+type Journeys struct {
+	cfg     JourneyConfig
+	seed    int64
+	nonce   uuid.UUID
+	prices  []float64
+	streams map[string]*stream
+	funnel  Funnel
+}
+
+// stream is the sessions of one target. It draws from a source of its own,
+// so what a target is sent does not depend on how its events interleave
+// with those of other targets.
+type stream struct {
+	target string
+	nonce  uuid.UUID
+	rng    *rand.Rand
+	zipf   *rand.Zipf
+	slots  []*session
+	nextID int64
+
+	// types are cumulative weights of the event types to draw standalone
+	// events from, in the order of event.EventTypes. Empty means sessions.
+	types []typeWeight
+}
+
+type typeWeight struct {
+	eventType  event.EventType
+	cumulative float64
+}
+
+func NewJourneys(cfg JourneyConfig, seed int64) *Journeys {
+	j := &Journeys{
+		cfg:     cfg,
+		seed:    seed,
+		nonce:   uuid.New(),
+		prices:  make([]float64, cfg.Products),
+		streams: map[string]*stream{},
+	}
+	for i := range j.prices {
+		j.prices[i] = productPrice(productID(i))
+	}
+	return j
+}
+
+func (j *Journeys) stream(target string) *stream {
+	st, ok := j.streams[target]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(target))
+		rng := rand.New(rand.NewSource(j.seed ^ int64(h.Sum64())))
+		st = &stream{
+			target: target,
+			nonce:  j.nonce,
+			rng:    rng,
+			zipf:   rand.NewZipf(rng, j.cfg.ZipfS, 1, uint64(j.cfg.Products-1)),
+			slots:  make([]*session, j.cfg.Sessions),
+		}
+		j.streams[target] = st
+	}
+	return st
+}
+
+// event is a new event with an ID drawn from the stream's source and
+// mixed with the run's nonce.
+func (st *stream) event(eventType event.EventType, userID string, metadata map[string]interface{}) event.Event {
+	e := event.New(eventType, userID, metadata)
+	id, _ := uuid.NewRandomFromReader(st.rng) // reading a rand.Rand never fails
+	e.EventID = uuid.NewSHA1(st.nonce, id[:]).String()
+	return e
+}
+
+// productPrice is the price of a product, the same on every run: between
+// 5 and 500, skewed towards the cheap end.
+func productPrice(id string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	u := float64(h.Sum64()%10000) / 10000
+	return math.Round((5+495*u*u)*100) / 100
+}
+
+func productID(i int) string {
+	return fmt.Sprintf("prod-%05d", i+1)
+}
+
+// SetEventTypes makes target get standalone events of the types drawn by
+// weights instead of whole sessions.
+func (j *Journeys) SetEventTypes(target string, weights map[event.EventType]float64) {
+	st := j.stream(target)
+	st.types = nil
+	total := 0.0
+	for _, t := range event.EventTypes {
+		if w := weights[t]; w > 0 {
+			total += w
+			st.types = append(st.types, typeWeight{eventType: t, cumulative: total})
+		}
+	}
+}
+
+// Next returns the next event of a session sent to target: one of its
+// running sessions moves on, or a new one starts in a free slot.
+func (j *Journeys) Next(target string) event.Event {
+	st := j.stream(target)
+	if len(st.types) > 0 {
+		return j.single(st)
+	}
+	slots := st.slots
+	i := st.rng.Intn(len(slots))
+	s := slots[i]
+	if s == nil {
+		s = j.start(st)
+		slots[i] = s
+		return j.view(st, s)
+	}
+
+	var t Transitions
+	switch s.last {
+	case event.AddToCart:
+		t = j.cfg.AfterCart
+	default:
+		t = j.cfg.AfterView
+	}
+	p := st.rng.Float64()
+	switch {
+	case p < t.View:
+		return j.view(st, s)
+	case p < t.View+t.Cart:
+		return j.addToCart(st, s)
+	case p < t.View+t.Cart+t.Checkout && len(s.cart) > 0:
+		slots[i] = nil
+		return j.checkout(st, s)
+	default:
+		// The user leaves; their slot starts a new session instead.
+		j.funnel.DropOffs++
+		s = j.start(st)
+		slots[i] = s
+		return j.view(st, s)
+	}
+}
+
+// Funnel returns what the sessions generated so far did.
+func (j *Journeys) Funnel() Funnel {
+	return j.funnel
+}
+
+// single is a standalone event of a type drawn from the stream's weights,
+// in a session of its own that is not counted in the funnel's sessions.
+func (j *Journeys) single(st *stream) event.Event {
+	p := st.rng.Float64() * st.types[len(st.types)-1].cumulative
+	eventType := st.types[len(st.types)-1].eventType
+	for _, t := range st.types {
+		if p < t.cumulative {
+			eventType = t.eventType
+			break
+		}
+	}
+	s := j.newSession(st)
+	switch eventType {
+	case event.AddToCart:
+		s.product = int(st.zipf.Uint64())
+		return j.addToCart(st, s)
+	case event.Checkout:
+		for n := 1 + st.rng.Intn(3); n > 0; n-- {
+			s.cart = append(s.cart, cartItem{product: int(st.zipf.Uint64()), quantity: 1})
+		}
+		return j.checkout(st, s)
+	default:
+		return j.view(st, s)
+	}
+}
+
+func (j *Journeys) start(st *stream) *session {
+	j.funnel.Sessions++
+	return j.newSession(st)
+}
+
+func (j *Journeys) newSession(st *stream) *session {
+	st.nextID++
+	return &session{
+		id:   fmt.Sprintf("sess-%s-%08d", st.target, st.nextID),
+		user: fmt.Sprintf("user-%06d", st.rng.Intn(j.cfg.Users)+1),
+	}
+}
+
+func (j *Journeys) view(st *stream, s *session) event.Event {
+	s.product = int(st.zipf.Uint64())
+	s.last = event.ViewProduct
+	j.funnel.Views++
+	return st.event(event.ViewProduct, s.user, map[string]interface{}{
+		"session_id": s.id,
+		"product_id": productID(s.product),
+		"price":      j.prices[s.product],
+	})
+}
+
+// addToCart adds the product last viewed.
+func (j *Journeys) addToCart(st *stream, s *session) event.Event {
+	qty := 1
+	if st.rng.Float64() < 0.2 {
+		qty += 1 + st.rng.Intn(2)
+	}
+	s.cart = append(s.cart, cartItem{product: s.product, quantity: qty})
+	s.last = event.AddToCart
+	j.funnel.Carts++
+	return st.event(event.AddToCart, s.user, map[string]interface{}{
+		"session_id": s.id,
+		"product_id": productID(s.product),
+		"price":      j.prices[s.product],
+		"quantity":   qty,
+	})
+}
+
+// checkout buys the cart and ends the session.
+func (j *Journeys) checkout(st *stream, s *session) event.Event {
+	items := make([]interface{}, len(s.cart))
+	total, count := 0.0, 0
+	for i, item := range s.cart {
+		price := j.prices[item.product]
+		items[i] = map[string]interface{}{
+			"product_id": productID(item.product),
+			"price":      price,
+			"quantity":   item.quantity,
+		}
+		total += price * float64(item.quantity)
+		count += item.quantity
+	}
+	j.funnel.Checkouts++
+	return st.event(event.Checkout, s.user, map[string]interface{}{
+		"session_id": s.id,
+		"items":      items,
+		"item_count": count,
+		"total":      math.Round(total*100) / 100,
+	})
+}
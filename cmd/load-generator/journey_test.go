@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJourneysSeed(t *testing.T) {
+	a, b := NewJourneys(DefaultJourney(), 42), NewJourneys(DefaultJourney(), 42)
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		ea, eb := a.Next("kafka"), b.Next("kafka")
+		if ea.EventID == eb.EventID {
+			t.Fatalf("event %d has ID %s in both runs", i, ea.EventID)
+		}
+		if seen[ea.EventID] {
+			t.Fatalf("event %d reuses ID %s", i, ea.EventID)
+		}
+		seen[ea.EventID] = true
+
+		ea.EventID, eb.EventID = "", ""
+		ea.Timestamp, eb.Timestamp = 0, 0
+		if !reflect.DeepEqual(ea, eb) {
+			t.Fatalf("event %d differs between runs with the same seed:\n%+v\n%+v", i, ea, eb)
+		}
+	}
+}
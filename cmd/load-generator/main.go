@@ -1,31 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
-	"math"
-	"math/rand"
-	"net"
-	"net/http"
 	"os"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
 	"github.com/joho/godotenv"
 )
 
-type job struct {
-	url string
-	evt event.Event
-}
-
 func getBaseURL() string {
 	godotenv.Load()
 	if base := os.Getenv("BASE_URL"); base == "" {
@@ -35,226 +21,208 @@ func getBaseURL() string {
 	}
 }
 
-func buildHTTPClient(maxConns int, timeout time.Duration) *http.Client {
-	tr := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           (&net.Dialer{Timeout: 3 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          maxConns * 2,
-		MaxIdleConnsPerHost:   maxConns,
-		MaxConnsPerHost:       maxConns, // cap to avoid unbounded growth
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   3 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	}
-	return &http.Client{
-		Transport: tr,
-		Timeout:   timeout,
+func main() {
+	if len(os.Args) > 1 {
+		var cmd func([]string) error
+		switch os.Args[1] {
+		case "compare":
+			cmd = runCompare
+		case "agent":
+			cmd = runAgent
+		}
+		if cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
 	}
-}
 
-func sendEvent(client *http.Client, targetURL string, evt event.Event) (int, time.Duration, error) {
-	body, err := json.Marshal(evt)
+	var (
+		eps           = flag.Int("eps", 500, "Total events per second to generate across selected targets")
+		durationStr   = flag.String("duration", "15m", "Test duration, for example 15m")
+		targetType    = flag.String("type", "", "Comma-separated targets to send to, for example kafka,eventbridge; empty for every target")
+		mixArg        = flag.String("mix", "", "Weights of the targets, for example kafka=70,eventbridge=30; replaces the even split over -type")
+		targetsArg    = flag.String("targets", "", "Targets file (.yaml, .yml or .json) adding targets or setting their URL, headers, body template, batch size and event types")
+		concurrency   = flag.Int("concurrency", 200, "Number of concurrent workers")
+		timeoutMs     = flag.Int("timeout_ms", 3000, "Per request timeout in milliseconds")
+		rampStr       = flag.String("ramp", "0s", "Optional linear ramp up duration, e.g. 30s")
+		mode          = flag.String("mode", ModeOpen, "Scheduling: open (send on schedule, latency from the intended send time) or closed (wait for a free worker)")
+		lateMs        = flag.Int("late_ms", 10, "Count a send as late when it goes out this many milliseconds after its intended time")
+		queueSize     = flag.Int("queue", 0, "Events that may wait for a worker in open mode before further ones are dropped; 0 for two seconds at the peak rate")
+		users         = flag.Int("users", 10000, "Simulated user population")
+		products      = flag.Int("products", 500, "Products in the catalogue, with Zipf-distributed popularity")
+		sessions      = flag.Int("sessions", 200, "Concurrent user sessions per target")
+		reportArg     = flag.String("report", "", "Write the results to <path>.json, <path>.csv and <path>.html")
+		transport     = flag.String("transport", TransportHTTP, "How events reach the targets: http (POST to the producers at BASE_URL) or direct (publish to Kafka and EventBridge from the generator)")
+		verifyStr     = flag.String("verify", "0s", "Wait this long after the run, then look up every event sent in the sink; 0s skips verification")
+		verifyN       = flag.Int("verify_workers", 32, "Concurrent lookups while verifying")
+		scenarioArg   = flag.String("scenario", "", "Scenario file (.yaml, .yml or .json) of load phases; replaces -eps, -duration and -ramp")
+		seed          = flag.Int64("seed", 0, "Seed for the generated events; the same seed generates the same events for each target. 0 picks one from the clock")
+		recordArg     = flag.String("record", "", "Write every event generated, with the time it was scheduled for, to this file")
+		replayArg     = flag.String("replay", "", "Send the events of a recording made with -record instead of generating them; replaces -scenario, -eps, -duration, -ramp, -type and -seed")
+		replaySpeed   = flag.Float64("replay_speed", 1, "Replay the recording this many times faster than it was recorded")
+		agentsArg     = flag.String("agents", "", "Comma-separated host:port of agents started with 'load-generator agent'; splits the load between them and merges their results instead of sending it from here")
+		startDelayStr = flag.String("start_delay", "5s", "With -agents, how far ahead the agents are told to start together")
+		agentToken    = flag.String("agent_token", "", "With -agents, the token the agents were started with; defaults to AGENT_TOKEN")
+		agentCA       = flag.String("agent_ca", "", "With -agents, PEM file of the CA that signed the agents' certificates, if not a system one")
+	)
+	var targetURLs targetFlags
+	flag.Var(&targetURLs, "target", "Extra target as name=url, sent to over HTTP; may be repeated")
+	flag.Parse()
+	ctx := context.Background()
+	verifyAfter, err := time.ParseDuration(*verifyStr)
 	if err != nil {
-		return 0, 0, fmt.Errorf("marshal: %w", err)
+		logging.Fatal(ctx, "invalid -verify", logging.Err(err))
+	}
+	var base string
+	switch *transport {
+	case TransportHTTP:
+		if *agentsArg != "" {
+			// Agents fall back to their own BASE_URL.
+			godotenv.Load()
+			base = os.Getenv("BASE_URL")
+			break
+		}
+		base = getBaseURL()
+		fmt.Println("Base URL:", base)
+	case TransportDirect:
+	default:
+		logging.Fatal(ctx, "invalid -transport", "transport", *transport)
 	}
-	start := time.Now()
-	resp, err := client.Post(targetURL, "application/json", bytes.NewReader(body))
-	lat := time.Since(start)
+	startDelay, err := time.ParseDuration(*startDelayStr)
 	if err != nil {
-		return 0, lat, err
+		logging.Fatal(ctx, "invalid -start_delay", logging.Err(err))
 	}
-	defer resp.Body.Close()
-	return resp.StatusCode, lat, nil
-}
-
-func main() {
-	var (
-		eps         = flag.Int("eps", 500, "Total events per second to generate across selected targets")
-		durationStr = flag.String("duration", "15m", "Test duration, for example 15m")
-		targetType  = flag.String("type", "", "Target: kafka, eventbridge, or empty for both")
-		concurrency = flag.Int("concurrency", 200, "Number of concurrent workers")
-		timeoutMs   = flag.Int("timeout_ms", 3000, "Per request timeout in milliseconds")
-		rampStr     = flag.String("ramp", "0s", "Optional linear ramp up duration, e.g. 30s")
-	)
-	flag.Parse()
-	base := getBaseURL()
-	fmt.Println("Base URL:", base)
 
 	dur, err := time.ParseDuration(*durationStr)
 	if err != nil {
-		log.Fatalf("invalid duration: %v", err)
+		logging.Fatal(ctx, "invalid -duration", logging.Err(err))
 	}
 	ramp, err := time.ParseDuration(*rampStr)
 	if err != nil {
-		log.Fatalf("invalid ramp: %v", err)
-	}
-	targets := map[string]string{
-		"kafka":       base + "/kafka",
-		"eventbridge": base + "/eventbridge",
+		logging.Fatal(ctx, "invalid -ramp", logging.Err(err))
 	}
 
-	selected := []string{}
-	switch strings.ToLower(*targetType) {
-	case "":
-		selected = []string{"kafka", "eventbridge"}
-	case "kafka", "eventbridge":
-		selected = []string{strings.ToLower(*targetType)}
-	default:
-		log.Fatalf("invalid -type: %s", *targetType)
+	var fileTargets []TargetConfig
+	if *targetsArg != "" {
+		if fileTargets, err = LoadTargets(*targetsArg); err != nil {
+			logging.Fatal(ctx, "invalid -targets", logging.Err(err))
+		}
 	}
-	// Per target EPS so that the total is exactly -eps
-	perTargetEPS := float64(*eps) / float64(len(selected))
-
-	log.Printf("Starting load: total EPS=%d, per-target EPS=%.2f, duration=%s, concurrency=%d, ramp=%s",
-		*eps, perTargetEPS, dur, *concurrency, ramp)
-
-	// Data generators
-	rand.New(rand.NewSource(time.Now().UnixNano())) // Seed for random number generation
-	eventTypes := []string{"view_product", "add_to_cart", "checkout"}
-	users := []string{"user1", "user2", "user3", "user4", "user5"}
-	products := []string{"prod1", "prod2", "prod3", "prod4"}
-
-	buildEvent := func() event.Event {
-		return event.New(
-			event.EventTypes[rand.Intn(len(eventTypes))],
-			users[rand.Intn(len(users))],
-			map[string]interface{}{
-				"product_id": products[rand.Intn(len(products))],
-				"price":      math.Round((rand.Float64()*100)*100) / 100, // 2dp
-			},
-		)
+	cfg := RunConfig{Transport: *transport, TargetConfigs: mergeTargets(fileTargets, targetURLs)}
+	if err := cfg.ValidateTargets(); err != nil {
+		logging.Fatal(ctx, "invalid targets", logging.Err(err))
 	}
-
-	// Worker pool
-	client := buildHTTPClient(*concurrency, time.Duration(*timeoutMs)*time.Millisecond)
-	jobs := make(chan job, *eps*2) // small burst buffer
-	ctx, cancel := context.WithTimeout(context.Background(), dur)
-	defer cancel()
-
-	var sent int64
-	var okCount int64
-	var errCount int64
-	var bytesSent int64
-	var latSumMicros int64
-
-	wg := &sync.WaitGroup{}
-	worker := func() {
-		defer wg.Done()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case j := <-jobs:
-				status, lat, err := sendEvent(client, j.url, j.evt)
-				atomic.AddInt64(&sent, 1)
-				atomic.AddInt64(&latSumMicros, lat.Microseconds())
-				if err != nil || status >= 400 {
-					atomic.AddInt64(&errCount, 1)
-				} else {
-					atomic.AddInt64(&okCount, 1)
-					atomic.AddInt64(&bytesSent, int64(len(j.evt.EventID))) // cheap placeholder
-				}
+	names := cfg.TargetNames()
+	selected := names
+	if *targetType != "" {
+		selected = nil
+		for _, name := range strings.Split(*targetType, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !contains(names, name) {
+				logging.Fatal(ctx, "invalid -type", "type", name, "targets", strings.Join(names, ","))
+			}
+			if !contains(selected, name) {
+				selected = append(selected, name)
 			}
 		}
 	}
 
-	for i := 0; i < *concurrency; i++ {
-		wg.Add(1)
-		go worker()
+	sc := ConstantScenario(float64(*eps), dur, ramp)
+	if *scenarioArg != "" {
+		if sc, err = LoadScenario(*scenarioArg); err != nil {
+			logging.Fatal(ctx, "invalid -scenario", logging.Err(err))
+		}
 	}
-
-	// Scheduler: 100 Hz tick. Each tick enqueues a slice of events for each target.
-	// We keep fractional carry so the average per second is exact.
-	ticker := time.NewTicker(10 * time.Millisecond)
-	defer ticker.Stop()
-
-	startWall := time.Now()
-	lastReport := startWall
-	var carry map[string]float64 = map[string]float64{}
-	for _, t := range selected {
-		carry[t] = 0
+	if *mixArg != "" {
+		if sc.Mix, err = parseWeights(*mixArg); err != nil {
+			logging.Fatal(ctx, "invalid -mix", logging.Err(err))
+		}
+	}
+	if err := sc.Validate(names); err != nil {
+		logging.Fatal(ctx, "invalid scenario", logging.Err(err))
+	}
+	if err := sc.ValidateSelected(selected); err != nil {
+		logging.Fatal(ctx, "invalid -type", logging.Err(err))
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	if *replayArg != "" {
+		if *recordArg != "" {
+			logging.Fatal(ctx, "-record and -replay cannot be used together")
+		}
+		if *replaySpeed <= 0 {
+			logging.Fatal(ctx, "invalid -replay_speed", "speed", *replaySpeed)
+		}
+	}
+	if *mode != ModeOpen && *mode != ModeClosed {
+		logging.Fatal(ctx, "invalid -mode", "mode", *mode)
 	}
 
-	rampEnd := startWall.Add(ramp)
-
-loop:
-	for {
-		select {
-		case <-ctx.Done():
-			break loop
-		case now := <-ticker.C:
-			elapsed := now.Sub(startWall)
-
-			// Compute current allowed EPS under ramp
-			rampFactor := 1.0
-			if ramp > 0 && now.Before(rampEnd) {
-				rampFactor = float64(now.Sub(startWall)) / float64(ramp)
-				if rampFactor < 0 {
-					rampFactor = 0
-				}
-				if rampFactor > 1 {
-					rampFactor = 1
-				}
-			}
-
-			for _, name := range selected {
-				targetURL := targets[name]
-
-				targetEPS := perTargetEPS * rampFactor
-				perTick := targetEPS / 100.0 // 100 ticks per second
-				carry[name] += perTick
+	// Data generators
+	journey := DefaultJourney()
+	journey.Users, journey.Products, journey.Sessions = *users, *products, *sessions
+	journey = journey.Merge(sc.Journey)
+	if err := journey.Validate(); err != nil {
+		logging.Fatal(ctx, "invalid journey", logging.Err(err))
+	}
 
-				n := int(carry[name])
-				if n > 0 {
-					carry[name] -= float64(n)
-					for i := 0; i < n; i++ {
-						select {
-						case <-ctx.Done():
-							break loop
-						case jobs <- job{url: targetURL, evt: buildEvent()}:
-						}
-					}
-				}
-			}
+	cfg = RunConfig{
+		Scenario:      sc,
+		Mode:          *mode,
+		Transport:     *transport,
+		BaseURL:       base,
+		Concurrency:   *concurrency,
+		TimeoutMs:     *timeoutMs,
+		LateMs:        *lateMs,
+		Queue:         *queueSize,
+		Selected:      selected,
+		TargetConfigs: cfg.TargetConfigs,
+		Journey:       journey,
+		Seed:          *seed,
+		Record:        *recordArg,
+		Verify:        Duration(verifyAfter),
+		VerifyWorkers: *verifyN,
+	}
+	if *replayArg != "" {
+		cfg.Replay, cfg.ReplaySpeed = *replayArg, *replaySpeed
+	}
 
-			// Lightweight periodic report each second
-			if now.Sub(lastReport) >= time.Second {
-				s := atomic.LoadInt64(&sent)
-				ok := atomic.LoadInt64(&okCount)
-				errs := atomic.LoadInt64(&errCount)
-				latAvg := time.Duration(0)
-				if s > 0 {
-					latAvg = time.Duration(atomic.LoadInt64(&latSumMicros)/s) * time.Microsecond
-				}
-				achieved := float64(s) / time.Since(startWall).Seconds()
-				log.Printf("t=%4.1fs sent=%d ok=%d errs=%d eps=%.1f avg_lat=%s",
-					elapsed.Seconds(), s, ok, errs, achieved, latAvg)
-				lastReport = now
+	var report *Report
+	if *agentsArg != "" {
+		if cfg.Record != "" || cfg.Replay != "" {
+			logging.Fatal(ctx, "-record and -replay cannot be used with -agents")
+		}
+		godotenv.Load()
+		if *agentToken == "" {
+			*agentToken = os.Getenv("AGENT_TOKEN")
+		}
+		if *agentToken == "" {
+			logging.Fatal(ctx, "-agents needs -agent_token or AGENT_TOKEN")
+		}
+		if *agentCA != "" {
+			if err := trustAgentCA(*agentCA); err != nil {
+				logging.Fatal(ctx, "invalid -agent_ca", logging.Err(err))
 			}
 		}
+		report, err = Coordinate(ctx, cfg, strings.Split(*agentsArg, ","), *agentToken, startDelay)
+	} else {
+		cfg.expandEnv = true
+		report, err = Run(ctx, cfg, time.Now())
 	}
-
-	// Drain and shutdown
-	close(jobs)
-	wg.Wait()
-
-	total := atomic.LoadInt64(&sent)
-	ok := atomic.LoadInt64(&okCount)
-	errs := atomic.LoadInt64(&errCount)
-	avgLat := time.Duration(0)
-	if total > 0 {
-		avgLat = time.Duration(atomic.LoadInt64(&latSumMicros)/total) * time.Microsecond
+	if err != nil {
+		logging.Fatal(ctx, "load run failed", logging.Err(err))
 	}
-	achieved := float64(total) / time.Since(startWall).Seconds()
+	report.Print()
 
-	fmt.Println("====== Summary ======")
-	fmt.Printf("Duration:        %s\n", time.Since(startWall).Truncate(time.Millisecond))
-	fmt.Printf("Configured EPS:  %d (total)\n", *eps)
-	fmt.Printf("Achieved EPS:    %.2f\n", achieved)
-	fmt.Printf("Total sent:      %d\n", total)
-	fmt.Printf("Success:         %d\n", ok)
-	fmt.Printf("Errors:          %d\n", errs)
-	fmt.Printf("Avg latency:     %s\n", avgLat)
-	fmt.Printf("Concurrency:     %d workers\n", *concurrency)
+	if *reportArg != "" {
+		paths, err := WriteReport(*reportArg, report)
+		if err != nil {
+			logging.Fatal(ctx, "write report", logging.Err(err))
+		}
+		fmt.Printf("Report:          %s\n", strings.Join(paths, ", "))
+	}
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/google/uuid"
+)
+
+// recordingVersion is bumped when the recording format changes
+// incompatibly.
+const recordingVersion = 2
+
+// RecordingHeader is the first line of a recording and describes the run
+// it was made from. Every line after it is a RecordedRequest, in the order
+// the requests were scheduled, dropped ones included.
+type RecordingHeader struct {
+	Version  int       `json:"version"`
+	Seed     int64     `json:"seed"`
+	Scenario *Scenario `json:"scenario"`
+	Selected []string  `json:"selected"`
+}
+
+// RecordedRequest is the events of one request, the target they went to
+// and when they were meant to be sent, in microseconds from the start of
+// the run.
+type RecordedRequest struct {
+	At     int64         `json:"at_us"`
+	Target string        `json:"target"`
+	Events []event.Event `json:"events"`
+}
+
+// Recorder writes the events of a run to a file. It is not safe for
+// concurrent use.
+type Recorder struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	n   int64
+	err error // the first write that failed
+}
+
+func NewRecorder(path string, header RecordingHeader) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	r := &Recorder{f: f, w: w, enc: json.NewEncoder(w)}
+	header.Version = recordingVersion
+	if err := r.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records j, sent at offset from the start of the run. Once a write
+// fails the rest are skipped and Close reports the error.
+func (r *Recorder) Write(j job, offset time.Duration) {
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(RecordedRequest{At: offset.Microseconds(), Target: j.target, Events: j.evts})
+	r.n += int64(len(j.evts))
+}
+
+// Close flushes the recording and returns the number of events written.
+func (r *Recorder) Close() (int64, error) {
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	if err := r.f.Close(); r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		return 0, fmt.Errorf("record %s: %w", r.f.Name(), r.err)
+	}
+	return r.n, nil
+}
+
+// Recording is a recording being read back.
+type Recording struct {
+	Header RecordingHeader
+	f      *os.File
+	dec    *json.Decoder
+}
+
+// OpenRecording opens a recording and reads its header.
+func OpenRecording(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recording{f: f, dec: json.NewDecoder(bufio.NewReaderSize(f, 1<<20))}
+	err = r.dec.Decode(&r.Header)
+	switch {
+	case err != nil:
+	case r.Header.Version != recordingVersion:
+		err = fmt.Errorf("version %d, want %d", r.Header.Version, recordingVersion)
+	case r.Header.Scenario == nil:
+		err = errors.New("no scenario in header")
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("recording %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Next returns the next request recorded, or io.EOF after the last.
+func (r *Recording) Next() (*RecordedRequest, error) {
+	var rec RecordedRequest
+	if err := r.dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (r *Recording) Close() error {
+	return r.f.Close()
+}
+
+// replayer releases the events of a recording at their recorded times,
+// divided by speed. Each event is stamped with the time it is replayed at
+// and gets a new ID.
+type replayer struct {
+	dispatcher
+	rec     *Recording
+	senders map[string]Sender
+	speed   float64
+	start   time.Time
+	next    *RecordedRequest
+	err     error // why the replay stopped early, if it did
+}
+
+func newReplayer(rec *Recording, speed float64, mode string, jobs chan job, stats *Stats, start time.Time, senders map[string]Sender) *replayer {
+	return &replayer{
+		dispatcher: dispatcher{mode: mode, jobs: jobs, stats: stats},
+		rec:        rec,
+		senders:    senders,
+		speed:      speed,
+		start:      start,
+	}
+}
+
+// tick releases the recorded events due up to one tick from now. It
+// returns false once the recording is exhausted, ctx ends or a request
+// names a target without a sender, which sets err.
+func (r *replayer) tick(ctx context.Context, now time.Time) bool {
+	end := now.Add(tickInterval)
+	for {
+		if r.next == nil {
+			next, err := r.rec.Next()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					logging.Error(ctx, "read recording", logging.Err(err))
+				}
+				return false
+			}
+			r.next = next
+		}
+		if _, ok := r.senders[r.next.Target]; !ok {
+			r.err = fmt.Errorf("recorded request for target %q, which is not being sent to", r.next.Target)
+			return false
+		}
+		intended := r.start.Add(time.Duration(float64(r.next.At) / r.speed * float64(time.Microsecond)))
+		if intended.After(end) {
+			break
+		}
+		evts := r.next.Events
+		for i := range evts {
+			// A fresh ID keeps the replay from overwriting the recorded
+			// run's events in the sink.
+			evts[i].EventID = uuid.NewString()
+			evts[i].Timestamp = intended.UnixMilli()
+		}
+		if !r.dispatch(ctx, job{target: r.next.Target, evts: evts, intended: intended}) {
+			return false
+		}
+		r.next = nil
+	}
+	if now := time.Now(); r.mode == ModeClosed && now.After(end) {
+		// Time spent waiting for workers pushes the rest of the recording back.
+		r.start = r.start.Add(now.Sub(end))
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+)
+
+// writeRecording records one request for target and returns the path.
+func writeRecording(t *testing.T, selected []string, target string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "run.jsonl")
+	sc := &Scenario{Phases: []Phase{{Type: PhaseConstant, Duration: Duration(time.Second), EPS: 1}}}
+	rec, err := NewRecorder(path, RecordingHeader{Scenario: sc, Selected: selected})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Write(job{target: target, evts: []event.Event{{EventID: "recorded"}}}, 0)
+	if _, err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplayUnconfiguredTarget(t *testing.T) {
+	path := writeRecording(t, []string{"extra"}, "extra")
+	cfg := RunConfig{Replay: path, ReplaySpeed: 1, Mode: ModeOpen, Transport: TransportHTTP, Concurrency: 1}
+	if _, err := Run(context.Background(), cfg, time.Now()); err == nil {
+		t.Error("replayed a recording selecting a target that is not configured")
+	}
+}
+
+func TestReplayerUnknownTarget(t *testing.T) {
+	rec, err := OpenRecording(writeRecording(t, []string{"kafka"}, "extra"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	jobs := make(chan job, 1)
+	r := newReplayer(rec, 1, ModeOpen, jobs, NewStats(testStart, []string{"kafka"}), testStart, map[string]Sender{"kafka": nil})
+
+	if r.tick(context.Background(), testStart) {
+		t.Error("tick kept going after a request for a target without a sender")
+	}
+	if r.err == nil {
+		t.Error("no error for a request for a target without a sender")
+	}
+	if len(jobs) != 0 {
+		t.Errorf("dispatched %d jobs, want none", len(jobs))
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// reportVersion is bumped when the report document changes incompatibly.
+const reportVersion = 1
+
+// Report is the result of one run, written by -report and read back by
+// compare. Latencies are in microseconds.
+type Report struct {
+	Version     int            `json:"version"`
+	Command     []string       `json:"command"`
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	Config      RunConfig      `json:"config"`
+	Environment Environment    `json:"environment"`
+	Totals      Totals         `json:"totals"`
+	Latency     Latency        `json:"latency"`
+	Funnel      Funnel         `json:"funnel"`
+	Targets     []TargetReport `json:"targets"`
+
+	Verification *Verification `json:"verification,omitempty"` // with -verify
+	Agents       []AgentReport `json:"agents,omitempty"`       // with -agents
+}
+
+// RunConfig is how the generator was configured for the run.
+// It is also the plan a coordinator sends its agents.
+type RunConfig struct {
+	Scenario      *Scenario         `json:"scenario"`
+	Mode          string            `json:"mode"`
+	Transport     string            `json:"transport"`
+	BaseURL       string            `json:"base_url,omitempty"`
+	Concurrency   int               `json:"concurrency"` // per agent in a distributed run
+	TimeoutMs     int               `json:"timeout_ms"`
+	LateMs        int               `json:"late_ms"`
+	Queue         int               `json:"queue"`
+	Targets       map[string]string `json:"targets"`
+	Selected      []string          `json:"selected"`
+	Journey       JourneyConfig     `json:"journey"`
+	Seed          int64             `json:"seed"`
+	Record        string            `json:"record,omitempty"`
+	Replay        string            `json:"replay,omitempty"`
+	ReplaySpeed   float64           `json:"replay_speed,omitempty"`
+	Verify        Duration          `json:"verify,omitempty"`
+	VerifyWorkers int               `json:"verify_workers,omitempty"`
+	Agents        []string          `json:"agents,omitempty"`
+	TargetConfigs []TargetConfig    `json:"target_configs,omitempty"`
+
+	// expandEnv expands environment variables in target headers. It is
+	// set only for plans written on this machine, never for ones an agent
+	// receives.
+	expandEnv bool
+}
+
+// Environment is the machine and build the run came from.
+type Environment struct {
+	Hostname  string `json:"hostname"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	CPUs      int    `json:"cpus"`
+	Revision  string `json:"revision,omitempty"`
+}
+
+// Totals are the counts of the run over every target.
+type Totals struct {
+	DurationS     float64 `json:"duration_s"`
+	Sent          int64   `json:"sent"`
+	OK            int64   `json:"ok"`
+	Errors        int64   `json:"errors"`
+	Late          int64   `json:"late"`
+	Dropped       int64   `json:"dropped"`
+	BytesSent     int64   `json:"bytes_sent"`
+	BytesReceived int64   `json:"bytes_received"`
+	AchievedEPS   float64 `json:"achieved_eps"`
+}
+
+// Latency summarises a histogram.
+type Latency struct {
+	Count int64   `json:"count"`
+	Mean  float64 `json:"mean_us"`
+	P50   int64   `json:"p50_us"`
+	P90   int64   `json:"p90_us"`
+	P95   int64   `json:"p95_us"`
+	P99   int64   `json:"p99_us"`
+	P999  int64   `json:"p99_9_us"`
+	Max   int64   `json:"max_us"`
+}
+
+// TargetReport is the traffic to one target.
+type TargetReport struct {
+	Name             string           `json:"name"`
+	URL              string           `json:"url"`
+	Sent             int64            `json:"sent"`
+	OK               int64            `json:"ok"`
+	Errors           int64            `json:"errors"`
+	Late             int64            `json:"late"`
+	Dropped          int64            `json:"dropped"`
+	BytesSent        int64            `json:"bytes_sent"`
+	BytesReceived    int64            `json:"bytes_received"`
+	BytesPayloadOnly bool             `json:"bytes_payload_only,omitempty"` // only message keys and values were counted, and nothing received
+	Statuses         map[string]int64 `json:"statuses"`
+	ErrorsByType     map[string]int64 `json:"errors_by_type"`
+	Latency          Latency          `json:"latency"`
+	ServiceTime      Latency          `json:"service_time"`
+	Histogram        Histogram        `json:"histogram"`
+	ServiceHistogram Histogram        `json:"service_histogram"`
+	Series           []Second         `json:"series"`
+}
+
+// Histogram is the non-empty buckets of an HDR histogram, enough to
+// rebuild it.
+type Histogram []Bucket
+
+type Bucket struct {
+	Value int64 `json:"us"`
+	Count int64 `json:"count"`
+}
+
+func exportHistogram(h *hdrhistogram.Histogram) Histogram {
+	out := Histogram{}
+	for _, bar := range h.Distribution() {
+		if bar.Count > 0 {
+			out = append(out, Bucket{Value: bar.From, Count: bar.Count})
+		}
+	}
+	return out
+}
+
+// HDR rebuilds the histogram.
+func (h Histogram) HDR() *hdrhistogram.Histogram {
+	out := newHistogram()
+	for _, b := range h {
+		out.RecordValues(b.Value, b.Count)
+	}
+	return out
+}
+
+func summarise(h *hdrhistogram.Histogram) Latency {
+	return Latency{
+		Count: h.TotalCount(),
+		Mean:  h.Mean(),
+		P50:   h.ValueAtQuantile(50),
+		P90:   h.ValueAtQuantile(90),
+		P95:   h.ValueAtQuantile(95),
+		P99:   h.ValueAtQuantile(99),
+		P999:  h.ValueAtQuantile(99.9),
+		Max:   h.Max(),
+	}
+}
+
+func formatLatency(l Latency) string {
+	return fmt.Sprintf("p50=%s p90=%s p95=%s p99=%s p99.9=%s max=%s",
+		usString(l.P50), usString(l.P90), usString(l.P95), usString(l.P99), usString(l.P999), usString(l.Max))
+}
+
+// TargetReports returns the traffic to every target that got any.
+func (s *Stats) TargetReports(urls map[string]string) []TargetReport {
+	var out []TargetReport
+	for _, name := range s.active() {
+		t := s.targets[name]
+		t.mu.Lock()
+		out = append(out, TargetReport{
+			Name:             name,
+			URL:              urls[name],
+			Sent:             t.sent,
+			OK:               t.ok,
+			Errors:           t.sent - t.ok,
+			Late:             t.late,
+			Dropped:          t.dropped,
+			BytesSent:        t.bytesSent,
+			BytesReceived:    t.bytesReceived,
+			Statuses:         copyCounts(t.statuses),
+			ErrorsByType:     copyCounts(t.errors),
+			Latency:          summarise(t.hist),
+			ServiceTime:      summarise(t.service),
+			Histogram:        exportHistogram(t.hist),
+			ServiceHistogram: exportHistogram(t.service),
+			Series:           append([]Second(nil), t.series...),
+		})
+		t.mu.Unlock()
+	}
+	return out
+}
+
+func copyCounts(m map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// NewReport assembles the report of a run that started at start.
+func NewReport(cfg RunConfig, stats *Stats, funnel Funnel, start, end time.Time) *Report {
+	sent, ok, late, dropped := stats.Totals()
+	bytesSent, bytesReceived := stats.Bytes()
+	d := end.Sub(start)
+	return &Report{
+		Version:     reportVersion,
+		Command:     os.Args,
+		StartedAt:   start.UTC(),
+		FinishedAt:  end.UTC(),
+		Config:      cfg,
+		Environment: environment(),
+		Totals: Totals{
+			DurationS:     d.Seconds(),
+			Sent:          sent,
+			OK:            ok,
+			Errors:        sent - ok,
+			Late:          late,
+			Dropped:       dropped,
+			BytesSent:     bytesSent,
+			BytesReceived: bytesReceived,
+			AchievedEPS:   float64(sent) / d.Seconds(),
+		},
+		Latency: summarise(stats.Merged()),
+		Funnel:  funnel,
+		Targets: stats.TargetReports(cfg.Targets),
+	}
+}
+
+// Print writes the report to the console: the per-second time series and
+// totals of every target, then those of the whole run.
+func (r *Report) Print() {
+	if len(r.Targets) > 0 {
+		fmt.Println("====== Per second ======")
+		header := fmt.Sprintf("%8s", "second")
+		for _, t := range r.Targets {
+			header += fmt.Sprintf(" | %-44s", t.Name+" sent/err/late/drop p50/p99/max")
+		}
+		fmt.Println(header)
+		// Every target is ticked together, so their series line up.
+		for i, first := range r.Targets[0].Series {
+			line := fmt.Sprintf("%8d", first.Elapsed)
+			for _, t := range r.Targets {
+				cell := ""
+				if i < len(t.Series) {
+					sec := t.Series[i]
+					cell = fmt.Sprintf("%d/%d/%d/%d %s/%s/%s", sec.Sent, sec.Errors, sec.Late, sec.Dropped, usString(sec.P50), usString(sec.P99), usString(sec.Max))
+				}
+				line += fmt.Sprintf(" | %-44s", cell)
+			}
+			fmt.Println(line)
+		}
+	}
+
+	for _, t := range r.Targets {
+		fmt.Printf("------ %s ------\n", t.Name)
+		fmt.Printf("Sent:            %d (%d ok, %d errors)\n", t.Sent, t.OK, t.Errors)
+		fmt.Printf("Late:            %d\n", t.Late)
+		fmt.Printf("Dropped:         %d\n", t.Dropped)
+		if t.BytesPayloadOnly {
+			fmt.Printf("Bytes:           %s of payload sent, received not measured\n", formatBytes(t.BytesSent))
+		} else {
+			fmt.Printf("Bytes:           %s sent, %s received\n", formatBytes(t.BytesSent), formatBytes(t.BytesReceived))
+		}
+		fmt.Printf("Status codes:    %s\n", formatCounts(t.Statuses))
+		if len(t.ErrorsByType) > 0 {
+			fmt.Printf("Errors by type:  %s\n", formatCounts(t.ErrorsByType))
+		}
+		fmt.Printf("Latency:         %s\n", formatLatency(t.Latency))
+		fmt.Printf("Service time:    %s\n", formatLatency(t.ServiceTime))
+	}
+
+	cfg := r.Config
+	peak := cfg.Scenario.Peak()
+	if cfg.Replay != "" {
+		peak *= cfg.ReplaySpeed
+	}
+	duration := time.Duration(r.Totals.DurationS * float64(time.Second))
+	fmt.Println("====== Summary ======")
+	fmt.Printf("Duration:        %s\n", duration.Truncate(time.Millisecond))
+	fmt.Printf("Scenario:        %s (%d phases, peak %.0f EPS)\n", cfg.Scenario.Name, len(cfg.Scenario.Phases), peak)
+	if len(cfg.Scenario.Mix) > 0 {
+		fmt.Printf("Mix:             %s\n", formatWeights(cfg.Scenario.Mix))
+	}
+	fmt.Printf("Achieved EPS:    %.2f\n", r.Totals.AchievedEPS)
+	fmt.Printf("Total sent:      %d\n", r.Totals.Sent)
+	fmt.Printf("Success:         %d\n", r.Totals.OK)
+	fmt.Printf("Errors:          %d\n", r.Totals.Errors)
+	fmt.Printf("Late:            %d (more than %s behind schedule)\n", r.Totals.Late, time.Duration(cfg.LateMs)*time.Millisecond)
+	fmt.Printf("Dropped:         %d\n", r.Totals.Dropped)
+	fmt.Printf("Bytes:           %s sent, %s received (%s/s out)%s\n",
+		formatBytes(r.Totals.BytesSent), formatBytes(r.Totals.BytesReceived), formatBytes(int64(float64(r.Totals.BytesSent)/r.Totals.DurationS)), payloadNote(r))
+	fmt.Printf("Avg latency:     %s\n", usString(int64(r.Latency.Mean)))
+	fmt.Printf("Latency:         %s\n", formatLatency(r.Latency))
+	if cfg.Replay != "" {
+		fmt.Printf("Replay:          %s at %gx\n", cfg.Replay, cfg.ReplaySpeed)
+	} else {
+		f := r.Funnel
+		fmt.Printf("Journeys:        %d sessions, %d views, %d add to cart, %d checkouts, %d drop-offs\n",
+			f.Sessions, f.Views, f.Carts, f.Checkouts, f.DropOffs)
+	}
+	fmt.Printf("Seed:            %d\n", cfg.Seed)
+	if cfg.Record != "" {
+		fmt.Printf("Recorded:        %s\n", cfg.Record)
+	}
+	fmt.Printf("Mode:            %s loop, %s transport\n", cfg.Mode, cfg.Transport)
+	if len(r.Agents) > 0 {
+		fmt.Printf("Concurrency:     %d workers on each of %d agents\n", cfg.Concurrency, len(r.Agents))
+		for _, a := range r.Agents {
+			a.Print()
+		}
+	} else {
+		fmt.Printf("Concurrency:     %d workers\n", cfg.Concurrency)
+	}
+	if r.Verification != nil {
+		r.Verification.Print()
+	}
+}
+
+func environment() Environment {
+	env := Environment{
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+	}
+	env.Hostname, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				env.Revision = s.Value
+			}
+		}
+	}
+	return env
+}
+
+// WriteReport writes the report to base.json, its time series to base.csv
+// and a chart of it to base.html, and returns the paths written.
+func WriteReport(base string, r *Report) ([]string, error) {
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	if dir := filepath.Dir(base); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	paths := []string{base + ".json", base + ".csv", base + ".html"}
+	writers := []func(*os.File, *Report) error{writeJSON, writeCSV, writeHTML}
+	for i, path := range paths {
+		if err := writeFile(path, r, writers[i]); err != nil {
+			return nil, fmt.Errorf("write %s: %w", path, err)
+		}
+	}
+	return paths, nil
+}
+
+func writeFile(path string, r *Report, write func(*os.File, *Report) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeJSON(f *os.File, r *Report) error {
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func writeCSV(f *os.File, r *Report) error {
+	w := csv.NewWriter(f)
+	w.Write([]string{"target", "elapsed_s", "sent", "ok", "errors", "late", "dropped", "bytes_sent", "bytes_received", "p50_us", "p90_us", "p99_us", "max_us"})
+	for _, t := range r.Targets {
+		for _, sec := range t.Series {
+			w.Write([]string{
+				t.Name,
+				strconv.Itoa(sec.Elapsed),
+				strconv.FormatInt(sec.Sent, 10),
+				strconv.FormatInt(sec.OK, 10),
+				strconv.FormatInt(sec.Errors, 10),
+				strconv.FormatInt(sec.Late, 10),
+				strconv.FormatInt(sec.Dropped, 10),
+				strconv.FormatInt(sec.BytesSent, 10),
+				strconv.FormatInt(sec.BytesReceived, 10),
+				strconv.FormatInt(sec.P50, 10),
+				strconv.FormatInt(sec.P90, 10),
+				strconv.FormatInt(sec.P99, 10),
+				strconv.FormatInt(sec.Max, 10),
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ReadReport loads a report written by WriteReport.
+func ReadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("report %s: %w", path, err)
+	}
+	if r.Version != reportVersion {
+		return nil, fmt.Errorf("report %s: version %d, want %d", path, r.Version, reportVersion)
+	}
+	return &r, nil
+}
+
+// payloadNote names the targets whose bytes are payload only, since the
+// totals include them.
+func payloadNote(r *Report) string {
+	var names []string
+	for _, t := range r.Targets {
+		if t.BytesPayloadOnly {
+			names = append(names, t.Name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", payload only for %s", strings.Join(names, ","))
+}
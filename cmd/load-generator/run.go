@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/config"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/storage"
+)
+
+type job struct {
+	target string
+	evts   []event.Event // more than one for targets that take batches
+
+	intended time.Time // when the scheduler meant the event to be sent
+}
+
+var loadConfig sync.Once
+
+// Run sends the load cfg describes, starting at start, and returns its
+// report once the run and any verification are over.
+func Run(ctx context.Context, cfg RunConfig, start time.Time) (*Report, error) {
+	sc := cfg.Scenario
+	var recording *Recording
+	if cfg.Replay != "" {
+		var err error
+		if recording, err = OpenRecording(cfg.Replay); err != nil {
+			return nil, err
+		}
+		defer recording.Close()
+		sc, cfg.Selected, cfg.Seed = recording.Header.Scenario, recording.Header.Selected, recording.Header.Seed
+		cfg.Scenario = sc
+		names := cfg.TargetNames()
+		for _, name := range cfg.Selected {
+			if !contains(names, name) {
+				return nil, fmt.Errorf("replay %s: target %q is not configured; pass the -target or -targets it was recorded with", cfg.Replay, name)
+			}
+		}
+		if err := sc.Validate(names); err != nil {
+			return nil, fmt.Errorf("replay %s: %w", cfg.Replay, err)
+		}
+		if err := sc.ValidateSelected(cfg.Selected); err != nil {
+			return nil, fmt.Errorf("replay %s: %w", cfg.Replay, err)
+		}
+	}
+	names := cfg.TargetNames()
+	dur := sc.Duration()
+	peak := sc.Peak()
+	if recording != nil {
+		dur = time.Duration(float64(dur) / cfg.ReplaySpeed)
+		peak *= cfg.ReplaySpeed
+	}
+
+	verifyAfter := time.Duration(cfg.Verify)
+	if cfg.Transport == TransportDirect || verifyAfter > 0 {
+		loadConfig.Do(func() { config.Load("event-pipeline-secret") })
+	}
+	var verifier *Verifier
+	if verifyAfter > 0 {
+		if err := checkVerifiable(&config.Cfg); err != nil {
+			return nil, err
+		}
+		verifier = NewVerifier()
+	}
+	journeys := NewJourneys(cfg.Journey, cfg.Seed)
+	for _, t := range cfg.TargetConfigs {
+		if len(t.EventTypes) > 0 {
+			journeys.SetEventTypes(t.Name, t.EventTypes)
+		}
+	}
+
+	// Worker pool
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	senders, targets, err := newSenders(cfg, sc.Targets(names, cfg.Selected), timeout)
+	if err != nil {
+		return nil, fmt.Errorf("create senders: %w", err)
+	}
+	cfg.Targets = targets
+	if cfg.Queue <= 0 {
+		cfg.Queue = int(peak*2) + 1 // two seconds at the peak rate
+	}
+	closeSenders := func() {
+		for _, sender := range senders {
+			sender.Close()
+		}
+	}
+	// In closed mode the scheduler hands each job straight to a free
+	// worker, so none waits in a queue.
+	jobs := make(chan job)
+	if cfg.Mode == ModeOpen {
+		jobs = make(chan job, cfg.Queue)
+	}
+	var recorder *Recorder
+	if cfg.Record != "" {
+		header := RecordingHeader{Seed: cfg.Seed, Scenario: sc, Selected: cfg.Selected}
+		if recorder, err = NewRecorder(cfg.Record, header); err != nil {
+			closeSenders()
+			return nil, err
+		}
+	}
+
+	if wait := time.Until(start); wait > 0 {
+		logging.Info(ctx, "waiting for start", "start", start.Format(time.RFC3339Nano))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			closeSenders()
+			if recorder != nil {
+				recorder.Close()
+			}
+			return nil, ctx.Err()
+		}
+	}
+	logging.Info(ctx, "starting load",
+		"scenario", sc.Name, "phases", len(sc.Phases), "mode", cfg.Mode, "peak_eps", peak, "duration", dur.String(), "concurrency", cfg.Concurrency, "seed", cfg.Seed)
+
+	runCtx, cancel := context.WithDeadline(ctx, start.Add(dur))
+	defer cancel()
+	stats := NewStats(start, names)
+	lateAfter := time.Duration(cfg.LateMs) * time.Millisecond
+	updateBytes := func() {
+		for name, sender := range senders {
+			sent, received := sender.Bytes()
+			stats.SetBytes(name, sent, received)
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	worker := func() {
+		defer wg.Done()
+		for j := range jobs {
+			if wait := time.Until(j.intended); wait > 0 {
+				time.Sleep(wait)
+			}
+			started := time.Now()
+			sendCtx, cancelSend := context.WithTimeout(context.Background(), timeout)
+			status, err := senders[j.target].Send(sendCtx, j.evts)
+			cancelSend()
+			service := time.Since(started)
+			lat := service
+			if cfg.Mode == ModeOpen {
+				lat = time.Since(j.intended)
+			}
+			stats.Record(j.target, len(j.evts), status, err, lat, service, started.Sub(j.intended) > lateAfter)
+			// Only the pipeline targets store what they are sent, so
+			// events sent to other targets are not looked for.
+			if verifier != nil && contains(builtinTargets, j.target) {
+				for _, evt := range j.evts {
+					verifier.Record(j.target, evt.EventID, err == nil && status < 400)
+				}
+			}
+		}
+	}
+
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go worker()
+	}
+
+	// Scheduler: 100 Hz tick. Each tick releases the events that fell due
+	// since the previous one, stamped with their intended send time.
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	var sched schedule
+	var replay *replayer
+	if recording != nil {
+		replay = newReplayer(recording, cfg.ReplaySpeed, cfg.Mode, jobs, stats, start, senders)
+		sched = replay
+	} else {
+		gen := newScheduler(cfg, jobs, stats, journeys.Next, start)
+		gen.recorder = recorder
+		sched = gen
+	}
+	lastReport := start
+
+loop:
+	for {
+		select {
+		case <-runCtx.Done():
+			break loop
+		case now := <-ticker.C:
+			if !sched.tick(runCtx, now) {
+				break loop
+			}
+
+			// Lightweight periodic report each second
+			if now.Sub(lastReport) >= time.Second {
+				updateBytes()
+				secs := stats.Tick(now)
+				s, ok, late, dropped := stats.Totals()
+				achieved := float64(s) / time.Since(start).Seconds()
+				logging.Info(ctx, "progress", append([]interface{}{
+					"elapsed_s", now.Sub(start).Seconds(), "sent", s, "ok", ok, "errors", s - ok, "late", late, "dropped", dropped, "eps", achieved,
+				}, stats.LogAttrs(secs)...)...)
+				lastReport = now
+			}
+		}
+	}
+
+	// Drain and shutdown
+	close(jobs)
+	wg.Wait()
+	updateBytes()
+	closeSenders()
+	if recorder != nil {
+		n, err := recorder.Close()
+		if err != nil {
+			return nil, err
+		}
+		logging.Info(ctx, "recorded", "events", n, "path", cfg.Record)
+	}
+	if replay != nil && replay.err != nil {
+		return nil, fmt.Errorf("replay %s: %w", cfg.Replay, replay.err)
+	}
+
+	end := time.Now()
+	stats.Tick(end)
+	report := NewReport(cfg, stats, journeys.Funnel(), start, end)
+	for i, t := range report.Targets {
+		if _, ok := senders[t.Name].(*kafkaSender); ok {
+			report.Targets[i].BytesPayloadOnly = true
+		}
+	}
+
+	if verifier != nil {
+		logging.Info(ctx, "waiting before verification", "grace", verifyAfter.String())
+		select {
+		case <-time.After(verifyAfter):
+		case <-ctx.Done():
+			logging.Warn(ctx, "run cancelled, skipping verification", logging.Err(ctx.Err()))
+			return report, nil
+		}
+		db, err := storage.Open(ctx, &config.Cfg)
+		if err != nil {
+			return nil, fmt.Errorf("open sink for verification: %w", err)
+		}
+		report.Verification = verifier.Verify(ctx, db, names, cfg.VerifyWorkers, verifyAfter)
+		if closer, ok := db.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logging.Warn(ctx, "failed to close sink after verification", logging.Err(err))
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Phase types.
+const (
+	PhaseConstant = "constant" // eps for the whole phase
+	PhaseStep     = "step"     // eps to to_eps in equal steps
+	PhaseRamp     = "ramp"     // eps to to_eps linearly
+	PhaseSpike    = "spike"    // eps, with spike_eps for spike_duration from spike_at
+	PhaseSine     = "sine"     // eps ± amplitude over period
+	PhasePause    = "pause"    // nothing sent
+)
+
+// Scenario is a sequence of load phases, run one after the other.
+type Scenario struct {
+	Name   string             `json:"name" yaml:"name"`
+	Mix    map[string]float64 `json:"mix,omitempty" yaml:"mix,omitempty"` // default target weights
+	Phases []Phase            `json:"phases" yaml:"phases"`
+
+	Journey *JourneyConfig `json:"journey,omitempty" yaml:"journey,omitempty"` // overrides the journey flags
+}
+
+// Phase is one stretch of a scenario with its own rate shape and target
+// mix. Fields not used by the phase type are ignored.
+type Phase struct {
+	Name     string             `json:"name,omitempty" yaml:"name,omitempty"`
+	Type     string             `json:"type" yaml:"type"`
+	Duration Duration           `json:"duration" yaml:"duration"`
+	EPS      float64            `json:"eps,omitempty" yaml:"eps,omitempty"`
+	ToEPS    float64            `json:"to_eps,omitempty" yaml:"to_eps,omitempty"`
+	Steps    int                `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Mix      map[string]float64 `json:"mix,omitempty" yaml:"mix,omitempty"` // overrides the scenario mix
+
+	SpikeEPS      float64   `json:"spike_eps,omitempty" yaml:"spike_eps,omitempty"`
+	SpikeAt       *Duration `json:"spike_at,omitempty" yaml:"spike_at,omitempty"` // from the phase start, default its middle
+	SpikeDuration Duration  `json:"spike_duration,omitempty" yaml:"spike_duration,omitempty"`
+
+	Amplitude float64  `json:"amplitude,omitempty" yaml:"amplitude,omitempty"`
+	Period    Duration `json:"period,omitempty" yaml:"period,omitempty"`
+}
+
+// Duration is a time.Duration written as "30s" in scenario files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	return d.parse(n.Value)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// decodeFile reads v from a .json, .yaml or .yml file. what names the
+// kind of file in errors.
+func decodeFile(path, what string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("%s %s: unknown format, use .json, .yaml or .yml", what, path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", what, path, err)
+	}
+	return nil
+}
+
+// LoadScenario reads a scenario from a .json, .yaml or .yml file.
+func LoadScenario(path string) (*Scenario, error) {
+	var sc Scenario
+	if err := decodeFile(path, "scenario", &sc); err != nil {
+		return nil, err
+	}
+	if sc.Name == "" {
+		sc.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	for i := range sc.Phases {
+		if sc.Phases[i].Name == "" {
+			sc.Phases[i].Name = fmt.Sprintf("%s-%d", sc.Phases[i].Type, i+1)
+		}
+	}
+	return &sc, nil
+}
+
+// ConstantScenario is the load of the -eps, -duration and -ramp flags: a
+// linear ramp, if any, then a constant rate.
+func ConstantScenario(eps float64, duration, ramp time.Duration) *Scenario {
+	sc := &Scenario{Name: "constant"}
+	if ramp > 0 {
+		sc.Phases = append(sc.Phases, Phase{Name: "ramp", Type: PhaseRamp, Duration: Duration(ramp), ToEPS: eps})
+	}
+	if rest := duration - ramp; rest > 0 {
+		sc.Phases = append(sc.Phases, Phase{Name: "steady", Type: PhaseConstant, Duration: Duration(rest), EPS: eps})
+	}
+	return sc
+}
+
+// Validate checks every phase and that mixes only name known targets.
+func (sc *Scenario) Validate(known []string) error {
+	if len(sc.Phases) == 0 {
+		return fmt.Errorf("scenario %s has no phases", sc.Name)
+	}
+	if err := validateMix(sc.Mix, known); err != nil {
+		return fmt.Errorf("scenario mix: %w", err)
+	}
+	for i, p := range sc.Phases {
+		name := p.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if p.Duration <= 0 {
+			return fmt.Errorf("phase %s: duration is required", name)
+		}
+		if p.EPS < 0 || p.ToEPS < 0 || p.SpikeEPS < 0 {
+			return fmt.Errorf("phase %s: rates cannot be negative", name)
+		}
+		switch p.Type {
+		case PhaseConstant, PhaseRamp, PhasePause:
+		case PhaseStep:
+			if p.Steps < 1 {
+				return fmt.Errorf("phase %s: steps must be at least 1", name)
+			}
+		case PhaseSpike:
+			if p.SpikeDuration <= 0 || p.spikeStart() < 0 || p.spikeStart()+time.Duration(p.SpikeDuration) > time.Duration(p.Duration) {
+				return fmt.Errorf("phase %s: the spike must fit within the phase", name)
+			}
+		case PhaseSine:
+			if p.Period <= 0 {
+				return fmt.Errorf("phase %s: period is required", name)
+			}
+		default:
+			return fmt.Errorf("phase %s: unknown type %q", name, p.Type)
+		}
+		if err := validateMix(p.Mix, known); err != nil {
+			return fmt.Errorf("phase %s mix: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateSelected checks that every phase sends to at least one of the
+// selected targets, since a mix only applies to those.
+func (sc *Scenario) ValidateSelected(selected []string) error {
+	for i := range sc.Phases {
+		if sc.Phases[i].Type == PhasePause {
+			continue
+		}
+		if len(sc.TargetMix(&sc.Phases[i], selected)) == 0 {
+			name := sc.Phases[i].Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return fmt.Errorf("phase %s: mix has no weight for %s", name, strings.Join(selected, ","))
+		}
+	}
+	return nil
+}
+
+func validateMix(mix map[string]float64, known []string) error {
+	total := 0.0
+	for name, w := range mix {
+		if !contains(known, name) {
+			return fmt.Errorf("unknown target %q", name)
+		}
+		if w < 0 {
+			return fmt.Errorf("negative weight for %s", name)
+		}
+		total += w
+	}
+	if len(mix) > 0 && total == 0 {
+		return fmt.Errorf("weights add up to zero")
+	}
+	return nil
+}
+
+// Duration is the total length of the scenario.
+func (sc *Scenario) Duration() time.Duration {
+	var d time.Duration
+	for _, p := range sc.Phases {
+		d += time.Duration(p.Duration)
+	}
+	return d
+}
+
+// Peak is the highest rate any phase asks for.
+func (sc *Scenario) Peak() float64 {
+	peak := 0.0
+	for _, p := range sc.Phases {
+		switch p.Type {
+		case PhaseConstant:
+			peak = math.Max(peak, p.EPS)
+		case PhaseRamp, PhaseStep:
+			peak = math.Max(peak, math.Max(p.EPS, p.ToEPS))
+		case PhaseSpike:
+			peak = math.Max(peak, math.Max(p.EPS, p.SpikeEPS))
+		case PhaseSine:
+			peak = math.Max(peak, p.EPS+math.Abs(p.Amplitude))
+		}
+	}
+	return peak
+}
+
+// At returns the phase running at elapsed and its offset into it, or false
+// once the scenario is over.
+func (sc *Scenario) At(elapsed time.Duration) (*Phase, time.Duration, bool) {
+	for i := range sc.Phases {
+		d := time.Duration(sc.Phases[i].Duration)
+		if elapsed < d {
+			return &sc.Phases[i], elapsed, true
+		}
+		elapsed -= d
+	}
+	return nil, 0, false
+}
+
+// Rate is the total events per second the phase asks for at offset t.
+func (p *Phase) Rate(t time.Duration) float64 {
+	progress := float64(t) / float64(p.Duration)
+	switch p.Type {
+	case PhaseConstant:
+		return p.EPS
+	case PhaseRamp:
+		return p.EPS + (p.ToEPS-p.EPS)*progress
+	case PhaseStep:
+		step := math.Floor(progress * float64(p.Steps))
+		if p.Steps == 1 {
+			return p.EPS
+		}
+		return p.EPS + (p.ToEPS-p.EPS)*step/float64(p.Steps-1)
+	case PhaseSpike:
+		at := p.spikeStart()
+		if t >= at && t < at+time.Duration(p.SpikeDuration) {
+			return p.SpikeEPS
+		}
+		return p.EPS
+	case PhaseSine:
+		return math.Max(0, p.EPS+p.Amplitude*math.Sin(2*math.Pi*float64(t)/float64(p.Period)))
+	default:
+		return 0
+	}
+}
+
+// spikeStart is when the spike of a spike phase begins: spike_at when it is
+// set, otherwise the middle of the phase.
+func (p *Phase) spikeStart() time.Duration {
+	if p.SpikeAt != nil {
+		return time.Duration(*p.SpikeAt)
+	}
+	return (time.Duration(p.Duration) - time.Duration(p.SpikeDuration)) / 2
+}
+
+// Scaled returns a copy of the scenario with every rate multiplied by f.
+func (sc *Scenario) Scaled(f float64) *Scenario {
+	out := *sc
+	out.Phases = make([]Phase, len(sc.Phases))
+	for i, p := range sc.Phases {
+		p.EPS *= f
+		p.ToEPS *= f
+		p.SpikeEPS *= f
+		p.Amplitude *= f
+		out.Phases[i] = p
+	}
+	return &out
+}
+
+// Targets returns the selected targets any phase sends to, in the order of
+// known.
+func (sc *Scenario) Targets(known, selected []string) []string {
+	var names []string
+	for _, name := range known {
+		for i := range sc.Phases {
+			if sc.TargetMix(&sc.Phases[i], selected)[name] > 0 {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetMix is the share of the rate each selected target gets in the
+// phase: the phase mix, else the scenario mix, else an even split. A mix is
+// limited to the selected targets and its weights spread over those alone.
+func (sc *Scenario) TargetMix(p *Phase, selected []string) map[string]float64 {
+	mix := p.Mix
+	if len(mix) == 0 {
+		mix = sc.Mix
+	}
+	shares := map[string]float64{}
+	if len(mix) == 0 {
+		for _, name := range selected {
+			shares[name] = 1 / float64(len(selected))
+		}
+		return shares
+	}
+	total := 0.0
+	for name, w := range mix {
+		if contains(selected, name) {
+			total += w
+		}
+	}
+	if total == 0 {
+		return shares
+	}
+	for name, w := range mix {
+		if contains(selected, name) {
+			shares[name] = w / total
+		}
+	}
+	return shares
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTargetMix(t *testing.T) {
+	tests := []struct {
+		name     string
+		mix      map[string]float64
+		selected []string
+		want     map[string]float64
+	}{
+		{
+			name:     "even split without a mix",
+			selected: []string{"kafka", "eventbridge"},
+			want:     map[string]float64{"kafka": 0.5, "eventbridge": 0.5},
+		},
+		{
+			name:     "mix over every selected target",
+			mix:      map[string]float64{"kafka": 3, "eventbridge": 1},
+			selected: []string{"kafka", "eventbridge"},
+			want:     map[string]float64{"kafka": 0.75, "eventbridge": 0.25},
+		},
+		{
+			name:     "mix limited to the selected targets",
+			mix:      map[string]float64{"kafka": 50, "eventbridge": 30, "audit": 20},
+			selected: []string{"kafka", "audit"},
+			want:     map[string]float64{"kafka": 50.0 / 70, "audit": 20.0 / 70},
+		},
+		{
+			name:     "mix without a selected target",
+			mix:      map[string]float64{"eventbridge": 1},
+			selected: []string{"kafka"},
+			want:     map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &Scenario{Mix: tt.mix}
+			got := sc.TargetMix(&Phase{}, tt.selected)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for name, share := range tt.want {
+				if math.Abs(got[name]-share) > 1e-9 {
+					t.Errorf("%s gets %v, want %v", name, got[name], share)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSelected(t *testing.T) {
+	sc := &Scenario{Phases: []Phase{
+		{Name: "quiet", Type: PhasePause, Mix: map[string]float64{"eventbridge": 1}},
+		{Name: "load", Type: PhaseConstant, Mix: map[string]float64{"eventbridge": 1}},
+	}}
+	if err := sc.ValidateSelected([]string{"eventbridge"}); err != nil {
+		t.Errorf("selected target in the mix: %v", err)
+	}
+	if err := sc.ValidateSelected([]string{"kafka"}); err == nil {
+		t.Error("accepted a phase whose mix has none of the selected targets")
+	}
+}
+
+func TestRate(t *testing.T) {
+	sec := func(s float64) Duration { return Duration(s * float64(time.Second)) }
+	from := func(s float64) *Duration { d := sec(s); return &d }
+	tests := []struct {
+		name  string
+		phase Phase
+		at    float64 // seconds into the phase
+		want  float64
+	}{
+		{"constant", Phase{Type: PhaseConstant, Duration: sec(10), EPS: 100}, 7, 100},
+		{"ramp start", Phase{Type: PhaseRamp, Duration: sec(10), EPS: 100, ToEPS: 200}, 0, 100},
+		{"ramp middle", Phase{Type: PhaseRamp, Duration: sec(10), EPS: 100, ToEPS: 200}, 5, 150},
+		{"first step", Phase{Type: PhaseStep, Duration: sec(9), EPS: 100, ToEPS: 300, Steps: 3}, 2.9, 100},
+		{"second step", Phase{Type: PhaseStep, Duration: sec(9), EPS: 100, ToEPS: 300, Steps: 3}, 3, 200},
+		{"last step", Phase{Type: PhaseStep, Duration: sec(9), EPS: 100, ToEPS: 300, Steps: 3}, 8.9, 300},
+		{"single step", Phase{Type: PhaseStep, Duration: sec(9), EPS: 100, ToEPS: 300, Steps: 1}, 8, 100},
+		{"before the middle spike", Phase{Type: PhaseSpike, Duration: sec(10), EPS: 100, SpikeEPS: 1000, SpikeDuration: sec(2)}, 3.9, 100},
+		{"in the middle spike", Phase{Type: PhaseSpike, Duration: sec(10), EPS: 100, SpikeEPS: 1000, SpikeDuration: sec(2)}, 4, 1000},
+		{"after the middle spike", Phase{Type: PhaseSpike, Duration: sec(10), EPS: 100, SpikeEPS: 1000, SpikeDuration: sec(2)}, 6, 100},
+		{"in a placed spike", Phase{Type: PhaseSpike, Duration: sec(10), EPS: 100, SpikeEPS: 1000, SpikeAt: from(1), SpikeDuration: sec(1)}, 1.5, 1000},
+		{"in a spike at the start", Phase{Type: PhaseSpike, Duration: sec(10), EPS: 100, SpikeEPS: 1000, SpikeAt: from(0), SpikeDuration: sec(1)}, 0.5, 1000},
+		{"after a spike at the start", Phase{Type: PhaseSpike, Duration: sec(10), EPS: 100, SpikeEPS: 1000, SpikeAt: from(0), SpikeDuration: sec(1)}, 4.5, 100},
+		{"sine peak", Phase{Type: PhaseSine, Duration: sec(8), EPS: 100, Amplitude: 50, Period: sec(4)}, 1, 150},
+		{"sine trough", Phase{Type: PhaseSine, Duration: sec(8), EPS: 100, Amplitude: 50, Period: sec(4)}, 3, 50},
+		{"sine floored at zero", Phase{Type: PhaseSine, Duration: sec(8), EPS: 10, Amplitude: 50, Period: sec(4)}, 3, 0},
+		{"pause", Phase{Type: PhasePause, Duration: sec(10), EPS: 100}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.phase.Rate(time.Duration(tt.at * float64(time.Second)))
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("rate %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAt(t *testing.T) {
+	sc := &Scenario{Phases: []Phase{
+		{Name: "warmup", Duration: Duration(10 * time.Second)},
+		{Name: "steady", Duration: Duration(20 * time.Second)},
+	}}
+	tests := []struct {
+		elapsed    time.Duration
+		wantPhase  string
+		wantOffset time.Duration
+		wantOK     bool
+	}{
+		{0, "warmup", 0, true},
+		{9 * time.Second, "warmup", 9 * time.Second, true},
+		{10 * time.Second, "steady", 0, true},
+		{29 * time.Second, "steady", 19 * time.Second, true},
+		{30 * time.Second, "", 0, false},
+	}
+	for _, tt := range tests {
+		phase, offset, ok := sc.At(tt.elapsed)
+		if ok != tt.wantOK {
+			t.Errorf("At(%v) ok = %v, want %v", tt.elapsed, ok, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if phase.Name != tt.wantPhase || offset != tt.wantOffset {
+			t.Errorf("At(%v) = %s+%v, want %s+%v", tt.elapsed, phase.Name, offset, tt.wantPhase, tt.wantOffset)
+		}
+	}
+}
+
+func TestSpikeAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spike.yaml")
+	data := "phases:\n  - type: spike\n    duration: 10s\n    eps: 100\n    spike_eps: 1000\n    spike_at: 0s\n    spike_duration: 1s\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &sc.Phases[0]
+	if p.SpikeAt == nil || *p.SpikeAt != 0 {
+		t.Fatalf("spike_at: 0s loaded as %v, want an explicit zero", p.SpikeAt)
+	}
+	if r := p.Rate(0); r != 1000 {
+		t.Errorf("rate at the start %v, want the spike's 1000", r)
+	}
+
+	late := Duration(9500 * time.Millisecond)
+	p.SpikeAt = &late
+	if err := sc.Validate(nil); err == nil {
+		t.Error("accepted a spike running past the end of its phase")
+	}
+}
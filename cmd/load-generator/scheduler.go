@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+)
+
+// Scheduling modes.
+const (
+	// ModeOpen sends on schedule whether or not earlier requests have
+	// returned. Latency is measured from the intended send time, so time an
+	// event waits for a free worker counts, and events that find the queue
+	// full are dropped and reported.
+	ModeOpen = "open"
+	// ModeClosed waits for a free worker before scheduling more, so the
+	// offered rate falls when the system slows down. Latency is measured
+	// from the actual send.
+	ModeClosed = "closed"
+)
+
+// tickInterval is how often the scheduler releases due events.
+const tickInterval = 10 * time.Millisecond
+
+// schedule releases jobs to the workers on every tick until it returns
+// false.
+type schedule interface {
+	tick(ctx context.Context, now time.Time) bool
+}
+
+// dispatcher hands jobs to the workers the way the mode asks for.
+type dispatcher struct {
+	mode  string
+	jobs  chan job
+	stats *Stats
+}
+
+// dispatch hands j to a worker. In closed mode it waits for one, and
+// returns false if ctx ends first.
+func (d dispatcher) dispatch(ctx context.Context, j job) bool {
+	if d.mode == ModeClosed {
+		select {
+		case d.jobs <- j:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	select {
+	case d.jobs <- j:
+	default:
+		d.stats.Drop(j.target, len(j.evts))
+	}
+	return true
+}
+
+// scheduler turns the scenario into jobs, each stamped with the time it was
+// meant to be sent.
+type scheduler struct {
+	dispatcher
+	sc       *Scenario
+	names    []string
+	selected []string
+	batch    map[string]int // events per request, per target
+	build    func(target string) event.Event
+	recorder *Recorder // nil unless the run is recorded
+	clock    func() time.Time
+
+	start   time.Time
+	until   time.Time          // end of the window released so far
+	credit  map[string]float64 // requests due but not yet released, per target
+	current *Phase
+}
+
+func newScheduler(cfg RunConfig, jobs chan job, stats *Stats, build func(target string) event.Event, start time.Time) *scheduler {
+	s := &scheduler{
+		dispatcher: dispatcher{mode: cfg.Mode, jobs: jobs, stats: stats},
+		sc:         cfg.Scenario,
+		names:      cfg.TargetNames(),
+		selected:   cfg.Selected,
+		batch:      map[string]int{},
+		build:      build,
+		start:      start,
+		until:      start,
+		credit:     map[string]float64{},
+		clock:      time.Now,
+	}
+	for _, name := range s.names {
+		s.batch[name] = max(cfg.TargetConfig(name).Batch, 1)
+	}
+	return s
+}
+
+// tick releases the events due up to one tick from now, so workers can
+// send each one at its intended time. It returns false once the scenario
+// is over or ctx ends.
+func (s *scheduler) tick(ctx context.Context, now time.Time) bool {
+	phase, offset, ok := s.sc.At(now.Sub(s.start))
+	if !ok {
+		return false
+	}
+	if phase != s.current {
+		s.current = phase
+		logging.Info(ctx, "phase", "name", phase.Name, "type", phase.Type, "duration", time.Duration(phase.Duration).String())
+	}
+
+	// The credit a target earns is its rate of requests times the length of
+	// the window since the last one released, so late or missed ticks do
+	// not lose events. A request is due when the credit, rising linearly
+	// over the window, crosses a whole number.
+	end := now.Add(tickInterval)
+	dt := end.Sub(s.until)
+	rate := phase.Rate(offset)
+	mix := s.sc.TargetMix(phase, s.selected)
+	for _, name := range s.names {
+		share, ok := mix[name]
+		if !ok {
+			continue
+		}
+		batch := s.batch[name]
+		from := s.credit[name]
+		to := from + rate*share*dt.Seconds()/float64(batch)
+		for k := math.Floor(from) + 1; k <= to; k++ {
+			intended := s.until.Add(time.Duration(float64(dt) * (k - from) / (to - from)))
+			j := job{target: name, evts: make([]event.Event, batch), intended: intended}
+			for i := range j.evts {
+				j.evts[i] = s.build(name)
+			}
+			if s.recorder != nil {
+				s.recorder.Write(j, intended.Sub(s.start))
+			}
+			if !s.dispatch(ctx, j) {
+				return false
+			}
+		}
+		s.credit[name] = to - math.Floor(to)
+	}
+
+	s.until = end
+	if now := s.clock(); s.mode == ModeClosed && now.After(end) {
+		// Time spent waiting for workers earns no credit.
+		s.until = now
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+)
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testScheduler returns a scheduler for sc and the channel it releases jobs
+// on.
+func testScheduler(t *testing.T, mode string, sc *Scenario, selected []string) (*scheduler, chan job) {
+	t.Helper()
+	cfg := RunConfig{Scenario: sc, Mode: mode, Selected: selected}
+	jobs := make(chan job, 10000)
+	s := newScheduler(cfg, jobs, NewStats(testStart, cfg.TargetNames()), func(string) event.Event { return event.Event{} }, testStart)
+	return s, jobs
+}
+
+// ticks runs the scheduler at each offset from the start, its clock
+// standing still at the tick, and returns what it released.
+func ticks(s *scheduler, jobs chan job, offsets ...time.Duration) []job {
+	for _, off := range offsets {
+		now := testStart.Add(off)
+		s.clock = func() time.Time { return now }
+		s.tick(context.Background(), now)
+	}
+	var out []job
+	for len(jobs) > 0 {
+		out = append(out, <-jobs)
+	}
+	return out
+}
+
+func every(d, until time.Duration) []time.Duration {
+	var out []time.Duration
+	for t := time.Duration(0); t < until; t += d {
+		out = append(out, t)
+	}
+	return out
+}
+
+func TestSchedulerTick(t *testing.T) {
+	constant := &Scenario{Phases: []Phase{{Type: PhaseConstant, Duration: Duration(time.Second), EPS: 100}}}
+	s, jobs := testScheduler(t, ModeOpen, constant, []string{"kafka"})
+	released := ticks(s, jobs, every(tickInterval, time.Second)...)
+
+	if len(released) != 100 {
+		t.Fatalf("released %d jobs, want 100", len(released))
+	}
+	for i, j := range released {
+		want := testStart.Add(time.Duration(i+1) * tickInterval)
+		if j.target != "kafka" || !j.intended.Equal(want) {
+			t.Fatalf("job %d: %s at %v, want kafka at %v", i, j.target, j.intended.Sub(testStart), want.Sub(testStart))
+		}
+	}
+	if s.tick(context.Background(), testStart.Add(time.Second)) {
+		t.Error("tick kept going after the scenario ended")
+	}
+}
+
+func TestSchedulerMissedTicks(t *testing.T) {
+	constant := &Scenario{Phases: []Phase{{Type: PhaseConstant, Duration: Duration(time.Second), EPS: 100}}}
+	s, jobs := testScheduler(t, ModeOpen, constant, []string{"kafka"})
+	// The tick at 10ms and 20ms never ran; the one at 30ms catches up.
+	released := ticks(s, jobs, 0, 30*time.Millisecond)
+
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 40 * time.Millisecond}
+	if len(released) != len(want) {
+		t.Fatalf("released %d jobs, want %d", len(released), len(want))
+	}
+	for i, j := range released {
+		if got := j.intended.Sub(testStart); got != want[i] {
+			t.Errorf("job %d intended at %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestSchedulerMix(t *testing.T) {
+	sc := &Scenario{
+		Mix:    map[string]float64{"kafka": 3, "eventbridge": 1},
+		Phases: []Phase{{Type: PhaseConstant, Duration: Duration(time.Second), EPS: 200}},
+	}
+	s, jobs := testScheduler(t, ModeOpen, sc, []string{"kafka", "eventbridge"})
+	counts := map[string]int{}
+	for _, j := range ticks(s, jobs, every(tickInterval, time.Second)...) {
+		counts[j.target]++
+	}
+	if counts["kafka"] != 150 || counts["eventbridge"] != 50 {
+		t.Errorf("released %v, want kafka 150 and eventbridge 50", counts)
+	}
+}
+
+func TestSchedulerRamp(t *testing.T) {
+	// Each tick uses the rate at its start, so 0 to 200 eps over two
+	// seconds releases 199 events rather than 200, 49 of them in the first.
+	ramp := &Scenario{Phases: []Phase{{Type: PhaseRamp, Duration: Duration(2 * time.Second), ToEPS: 200}}}
+	s, jobs := testScheduler(t, ModeOpen, ramp, []string{"kafka"})
+	first := ticks(s, jobs, every(tickInterval, time.Second)...)
+	rest := ticks(s, jobs, every(tickInterval, 2*time.Second)[100:]...)
+
+	if len(first) != 49 || len(first)+len(rest) != 199 {
+		t.Errorf("released %d jobs, %d in the first second, want 199 and 49", len(first)+len(rest), len(first))
+	}
+}
+
+func TestSchedulerClosedWait(t *testing.T) {
+	constant := &Scenario{Phases: []Phase{{Type: PhaseConstant, Duration: Duration(time.Second), EPS: 100}}}
+	s, jobs := testScheduler(t, ModeClosed, constant, []string{"kafka"})
+	// The first tick waits 50ms for workers, which earns no credit.
+	s.clock = func() time.Time { return testStart.Add(50 * time.Millisecond) }
+	s.tick(context.Background(), testStart)
+	released := ticks(s, jobs, 50*time.Millisecond)
+
+	if len(released) != 2 {
+		t.Fatalf("released %d jobs, want 2", len(released))
+	}
+	if got := released[1].intended.Sub(testStart); got != 60*time.Millisecond {
+		t.Errorf("job after the wait intended at %v, want 60ms", got)
+	}
+}
+
+func TestSchedulerClosedCancel(t *testing.T) {
+	constant := &Scenario{Phases: []Phase{{Type: PhaseConstant, Duration: Duration(time.Second), EPS: 100}}}
+	s, _ := testScheduler(t, ModeClosed, constant, []string{"kafka"})
+	s.jobs = make(chan job) // no worker ever takes a job
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan bool)
+	go func() { done <- s.tick(ctx, testStart) }()
+	select {
+	case more := <-done:
+		if more {
+			t.Error("tick kept going after its context ended")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("tick blocked on a job no worker took")
+	}
+}
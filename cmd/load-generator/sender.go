@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/config"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/eventbridge"
+	"github.com/Babatunde13/event-pipeline/internal/kafka"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// Transports the generator can reach targets through.
+const (
+	TransportHTTP   = "http"   // POST to the producers at BASE_URL
+	TransportDirect = "direct" // publish to the brokers as the producers do
+)
+
+// Sender delivers events to one target, in one request. Only HTTP senders
+// are given more than one event at a time. Direct senders report a
+// delivered event as status 200, so results read the same whatever the
+// transport.
+type Sender interface {
+	Send(ctx context.Context, evts []event.Event) (status int, err error)
+	// Bytes returns the bytes sent to and received from the target so far.
+	Bytes() (sent, received int64)
+	Close()
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// byteCounter counts the bytes read from and written to the connections it
+// dials, so headers, TLS and retries are included.
+type byteCounter struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (c *byteCounter) Bytes() (int64, int64) {
+	return c.sent.Load(), c.received.Load()
+}
+
+func (c *byteCounter) dial(next dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, counter: c}, nil
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	counter *byteCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counter.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counter.sent.Add(int64(n))
+	return n, err
+}
+
+func buildHTTPClient(maxConns int, timeout time.Duration, counter *byteCounter) *http.Client {
+	tr := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           counter.dial((&net.Dialer{Timeout: 3 * time.Second, KeepAlive: 30 * time.Second}).DialContext),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxConns * 2,
+		MaxIdleConnsPerHost:   maxConns,
+		MaxConnsPerHost:       maxConns, // cap to avoid unbounded growth
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Transport: tr,
+		Timeout:   timeout,
+	}
+}
+
+type httpSender struct {
+	*byteCounter
+	client  *http.Client
+	url     string
+	target  TargetConfig
+	tmpl    *template.Template // nil sends the events as JSON
+	headers map[string]string
+}
+
+func (s *httpSender) Send(ctx context.Context, evts []event.Event) (int, error) {
+	data, err := body(s.target, s.tmpl, evts)
+	if err != nil {
+		return 0, fmt.Errorf("body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Read the body so the connection is reused and its bytes are counted.
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func (s *httpSender) Close() {}
+
+// kafkaSender produces events to the pipeline topic, stamped the way the
+// Kafka producer stamps them. The producer keeps its own connections, so
+// only the messages are counted as sent and nothing as received, and the
+// report marks its bytes as payload only.
+type kafkaSender struct {
+	producer *kafka.Producer
+	sent     atomic.Int64
+}
+
+func (s *kafkaSender) Send(ctx context.Context, evts []event.Event) (int, error) {
+	evt := evts[0]
+	evt.Timestamp = event.Now()
+	evt.PublishedAt = event.Now()
+	data, err := evt.ToJSON()
+	if err != nil {
+		return 0, fmt.Errorf("marshal: %w", err)
+	}
+	if err := s.producer.SendMessage(ctx, evt.EventID, data); err != nil {
+		return 0, err
+	}
+	s.sent.Add(int64(len(evt.EventID) + len(data)))
+	return http.StatusOK, nil
+}
+
+func (s *kafkaSender) Bytes() (int64, int64) {
+	return s.sent.Load(), 0
+}
+
+func (s *kafkaSender) Close() {
+	s.producer.Close()
+}
+
+// eventBridgeSender puts events on the pipeline bus, stamped the way the
+// EventBridge producer stamps them.
+type eventBridgeSender struct {
+	*byteCounter
+	client *eventbridge.Client
+}
+
+func (s *eventBridgeSender) Send(ctx context.Context, evts []event.Event) (int, error) {
+	evt := evts[0]
+	evt.Timestamp = event.Now()
+	evt.PublishedAt = event.Now()
+	if err := s.client.PutEvent(ctx, config.Cfg.EventBusSource, string(evt.EventType), &evt); err != nil {
+		return 0, err
+	}
+	return http.StatusOK, nil
+}
+
+func (s *eventBridgeSender) Close() {}
+
+// newSenders returns a sender for each of the named targets, each with its
+// own connections of up to cfg.Concurrency. Direct senders need the
+// pipeline configuration to be loaded.
+func newSenders(cfg RunConfig, names []string, timeout time.Duration) (map[string]Sender, map[string]string, error) {
+	senders := map[string]Sender{}
+	dests := map[string]string{}
+	for _, name := range names {
+		counter := &byteCounter{}
+		if !cfg.direct(name) {
+			t := cfg.TargetConfig(name)
+			url := t.URL
+			if url == "" {
+				if cfg.BaseURL == "" {
+					return nil, nil, fmt.Errorf("target %s has no url and there is no base URL", name)
+				}
+				url = cfg.BaseURL + "/" + name
+			}
+			s := &httpSender{
+				byteCounter: counter,
+				client:      buildHTTPClient(cfg.Concurrency, timeout, counter),
+				url:         url,
+				target:      t,
+				headers:     t.Headers,
+			}
+			if cfg.expandEnv {
+				s.headers = expandHeaders(t)
+			}
+			if t.Template != "" {
+				var err error
+				if s.tmpl, err = parseBodyTemplate(t); err != nil {
+					return nil, nil, err
+				}
+			}
+			senders[name], dests[name] = s, url
+			continue
+		}
+		switch name {
+		case "kafka":
+			producer, err := kafka.NewProducer()
+			if err != nil {
+				return nil, nil, fmt.Errorf("kafka producer: %w", err)
+			}
+			senders[name], dests[name] = &kafkaSender{producer: producer}, "kafka://"+config.Cfg.KafkaTopic
+		case "eventbridge":
+			awsCfg := config.Cfg.AwsConfig.Copy()
+			awsCfg.HTTPClient = awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+				tr.DialContext = counter.dial(tr.DialContext)
+			})
+			client := eventbridge.New(awsCfg, config.Cfg.EventBusName)
+			senders[name], dests[name] = &eventBridgeSender{byteCounter: counter, client: client}, "eventbridge://"+config.Cfg.EventBusName
+		default:
+			return nil, nil, fmt.Errorf("no direct transport for target %s", name)
+		}
+	}
+	return senders, dests, nil
+}
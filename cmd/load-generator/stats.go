@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/aws/smithy-go"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Latencies are recorded in microseconds, from 1µs to a minute, to three
+// significant digits.
+const (
+	minLatency = 1
+	maxLatency = int64(time.Minute / time.Microsecond)
+	sigFigs    = 3
+)
+
+// statusError is the status of a request that got no response.
+const statusError = "error"
+
+func newHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(minLatency, maxLatency, sigFigs)
+}
+
+// Second is the traffic to one target during one second of the run.
+type Second struct {
+	Elapsed       int   `json:"elapsed_s"`
+	Sent          int64 `json:"sent"`
+	OK            int64 `json:"ok"`
+	Errors        int64 `json:"errors"`
+	Late          int64 `json:"late"`
+	Dropped       int64 `json:"dropped"`
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+	P50           int64 `json:"p50_us"`
+	P90           int64 `json:"p90_us"`
+	P99           int64 `json:"p99_us"`
+	Max           int64 `json:"max_us"`
+}
+
+// targetStats is the traffic to one target: over the whole run, and over
+// the second in progress. hist is latency from the intended send time,
+// service from the actual one.
+type targetStats struct {
+	mu       sync.Mutex
+	hist     *hdrhistogram.Histogram
+	service  *hdrhistogram.Histogram
+	second   *hdrhistogram.Histogram
+	statuses map[string]int64
+	errors   map[string]int64 // by errorType
+	sent     int64
+	ok       int64
+	late     int64
+	dropped  int64
+	current  Second
+	series   []Second
+
+	// Bytes to and from the target, as reported by its sender, and their
+	// values when the last second closed.
+	bytesSent, bytesReceived   int64
+	tickedSent, tickedReceived int64
+}
+
+// Stats collects latency histograms, status codes and a per-second time
+// series for each target.
+type Stats struct {
+	start   time.Time
+	order   []string
+	targets map[string]*targetStats
+}
+
+func NewStats(start time.Time, names []string) *Stats {
+	s := &Stats{start: start, order: names, targets: map[string]*targetStats{}}
+	for _, name := range names {
+		s.targets[name] = &targetStats{hist: newHistogram(), service: newHistogram(), second: newHistogram(), statuses: map[string]int64{}, errors: map[string]int64{}}
+	}
+	return s
+}
+
+// Record counts one request of n events to target. Sent, ok, late and
+// error counts are in events; status codes, error types and latencies are
+// per request. err is a request that got no response; a
+// status of 400 or more also counts as an error. lat is the latency from
+// the intended send time, service from the actual one, and late whether
+// the request went out later than it should have.
+func (s *Stats) Record(target string, n int, status int, err error, lat, service time.Duration, late bool) {
+	t := s.targets[target]
+	code := statusError
+	if err == nil {
+		code = strconv.Itoa(status)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hist.RecordValue(clampLatency(lat))
+	t.second.RecordValue(clampLatency(lat))
+	t.service.RecordValue(clampLatency(service))
+	t.statuses[code]++
+	events := int64(n)
+	if late {
+		t.late += events
+		t.current.Late += events
+	}
+	t.sent += events
+	t.current.Sent += events
+	if err == nil && status < 400 {
+		t.ok += events
+		t.current.OK += events
+	} else {
+		t.current.Errors += events
+		t.errors[errorType(status, err)]++
+	}
+}
+
+// Drop counts n events to target that were never sent because every
+// worker was busy and the queue was full.
+func (s *Stats) Drop(target string, n int) {
+	t := s.targets[target]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropped += int64(n)
+	t.current.Dropped += int64(n)
+}
+
+// SetBytes updates the bytes sent to and received from target since the
+// start of the run.
+func (s *Stats) SetBytes(target string, sent, received int64) {
+	t := s.targets[target]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytesSent, t.bytesReceived = sent, received
+}
+
+func clampLatency(d time.Duration) int64 {
+	return min(max(d.Microseconds(), minLatency), maxLatency)
+}
+
+// Tick closes the second in progress of every target, appends it to their
+// time series and returns them keyed by target.
+func (s *Stats) Tick(now time.Time) map[string]Second {
+	elapsed := int(now.Sub(s.start).Round(time.Second) / time.Second)
+	out := make(map[string]Second, len(s.targets))
+	for name, t := range s.targets {
+		t.mu.Lock()
+		sec := t.current
+		sec.Elapsed = elapsed
+		sec.BytesSent, sec.BytesReceived = t.bytesSent-t.tickedSent, t.bytesReceived-t.tickedReceived
+		t.tickedSent, t.tickedReceived = t.bytesSent, t.bytesReceived
+		sec.P50 = t.second.ValueAtQuantile(50)
+		sec.P90 = t.second.ValueAtQuantile(90)
+		sec.P99 = t.second.ValueAtQuantile(99)
+		sec.Max = t.second.Max()
+		t.series = append(t.series, sec)
+		t.current = Second{}
+		t.second.Reset()
+		t.mu.Unlock()
+		out[name] = sec
+	}
+	return out
+}
+
+// Totals returns the requests sent to every target, how many succeeded,
+// how many went out late and how many were dropped.
+func (s *Stats) Totals() (sent, ok, late, dropped int64) {
+	for _, t := range s.targets {
+		t.mu.Lock()
+		sent += t.sent
+		ok += t.ok
+		late += t.late
+		dropped += t.dropped
+		t.mu.Unlock()
+	}
+	return sent, ok, late, dropped
+}
+
+// Bytes returns the bytes sent to and received from every target.
+func (s *Stats) Bytes() (sent, received int64) {
+	for _, t := range s.targets {
+		t.mu.Lock()
+		sent += t.bytesSent
+		received += t.bytesReceived
+		t.mu.Unlock()
+	}
+	return sent, received
+}
+
+// Merged returns the latency histogram of every target together.
+func (s *Stats) Merged() *hdrhistogram.Histogram {
+	all := newHistogram()
+	for _, t := range s.targets {
+		t.mu.Lock()
+		all.Merge(t.hist)
+		t.mu.Unlock()
+	}
+	return all
+}
+
+// LogAttrs is the second just closed, as one log group per target.
+func (s *Stats) LogAttrs(secs map[string]Second) []interface{} {
+	var args []interface{}
+	for _, name := range s.order {
+		sec, ok := secs[name]
+		if !ok || (sec.Sent == 0 && sec.Dropped == 0 && !s.targets[name].active()) {
+			continue
+		}
+		args = append(args, slog.Group(name,
+			"sent", sec.Sent,
+			"errors", sec.Errors,
+			"late", sec.Late,
+			"dropped", sec.Dropped,
+			"bytes_sent", sec.BytesSent,
+			"bytes_received", sec.BytesReceived,
+			"p50", usString(sec.P50),
+			"p90", usString(sec.P90),
+			"p99", usString(sec.P99),
+			"max", usString(sec.Max),
+		))
+	}
+	return args
+}
+
+func (s *Stats) active() []string {
+	var names []string
+	for _, name := range s.order {
+		if s.targets[name].active() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// active reports whether anything was scheduled for the target.
+func (t *targetStats) active() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sent > 0 || t.dropped > 0
+}
+
+func formatCounts(counts map[string]int64) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, counts[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// errorType classifies a failed request: an HTTP error status, the error
+// code of a broker, or why it got no response.
+func errorType(status int, err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var apiErr smithy.APIError
+	var kafkaErr kafka.Error
+	switch {
+	case err == nil && status == http.StatusTooManyRequests:
+		return "http_throttled"
+	case err == nil && status >= 500:
+		return "http_5xx"
+	case err == nil:
+		return "http_4xx"
+	case errors.As(err, &apiErr):
+		return "aws_" + apiErr.ErrorCode()
+	case errors.As(err, &kafkaErr):
+		return "kafka_" + kafkaErr.Code().String()
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	default:
+		return "transport"
+	}
+}
+
+// formatBytes formats a byte count in decimal units.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// usString formats microseconds as a duration rounded for reading.
+func usString(us int64) string {
+	d := time.Duration(us) * time.Microsecond
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.String()
+	}
+}
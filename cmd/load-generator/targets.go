@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+)
+
+// builtinTargets are the producers of the pipeline, reachable over either
+// transport.
+var builtinTargets = []string{"kafka", "eventbridge"}
+
+// TargetConfig describes how to send to a target. It can add a target to
+// the built-in ones or change how one of those is sent to over HTTP.
+type TargetConfig struct {
+	Name    string            `json:"name" yaml:"name"`
+	URL     string            `json:"url,omitempty" yaml:"url,omitempty"`         // default BASE_URL/<name>
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"` // values may use ${ENV_VAR}
+
+	// Template is a text/template of the request body, given .Target,
+	// .Event and .Events. The default is the event as JSON, or a JSON array
+	// of them for a batch.
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+	// Batch is the number of events sent in each request. Rates and counts
+	// stay in events.
+	Batch int `json:"batch,omitempty" yaml:"batch,omitempty"`
+	// EventTypes weights the event types sent to the target. When set, the
+	// target gets standalone events drawn by these weights instead of
+	// whole sessions.
+	EventTypes map[event.EventType]float64 `json:"event_types,omitempty" yaml:"event_types,omitempty"`
+}
+
+// LoadTargets reads a list of targets from a .json, .yaml or .yml file.
+func LoadTargets(path string) ([]TargetConfig, error) {
+	var targets []TargetConfig
+	if err := decodeFile(path, "targets", &targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// TargetNames is every target the run knows of: the built-in ones, then
+// those configured, in the order they were given.
+func (c RunConfig) TargetNames() []string {
+	names := append([]string(nil), builtinTargets...)
+	for _, t := range c.TargetConfigs {
+		if !contains(names, t.Name) {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+// TargetConfig returns the configuration of the named target, or a zero
+// one with only the name when it has none.
+func (c RunConfig) TargetConfig(name string) TargetConfig {
+	for _, t := range c.TargetConfigs {
+		if t.Name == name {
+			return t
+		}
+	}
+	return TargetConfig{Name: name}
+}
+
+// ValidateTargets checks the configured targets.
+func (c RunConfig) ValidateTargets() error {
+	seen := map[string]bool{}
+	for _, t := range c.TargetConfigs {
+		switch {
+		case t.Name == "" || strings.ContainsAny(t.Name, "/ ,="):
+			return fmt.Errorf("target %q: a name without '/', ' ', ',' or '=' is required", t.Name)
+		case seen[t.Name]:
+			return fmt.Errorf("target %s: configured twice", t.Name)
+		case t.Batch < 0:
+			return fmt.Errorf("target %s: batch cannot be negative", t.Name)
+		}
+		seen[t.Name] = true
+		if c.direct(t.Name) && (t.URL != "" || len(t.Headers) > 0 || t.Template != "" || t.Batch > 1) {
+			return fmt.Errorf("target %s: url, headers, template and batch only apply over HTTP", t.Name)
+		}
+		if t.Template != "" {
+			if _, err := parseBodyTemplate(t); err != nil {
+				return err
+			}
+		}
+		if err := validateMix(eventTypeWeights(t.EventTypes), eventTypeNames()); err != nil {
+			return fmt.Errorf("target %s event types: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// direct reports whether the named target is sent to without HTTP.
+func (c RunConfig) direct(name string) bool {
+	return c.Transport == TransportDirect && contains(builtinTargets, name)
+}
+
+func eventTypeNames() []string {
+	names := make([]string, len(event.EventTypes))
+	for i, t := range event.EventTypes {
+		names[i] = string(t)
+	}
+	return names
+}
+
+func eventTypeWeights(m map[event.EventType]float64) map[string]float64 {
+	out := make(map[string]float64, len(m))
+	for t, w := range m {
+		out[string(t)] = w
+	}
+	return out
+}
+
+func parseBodyTemplate(t TargetConfig) (*template.Template, error) {
+	tmpl, err := template.New(t.Name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(t.Template)
+	if err != nil {
+		return nil, fmt.Errorf("target %s template: %w", t.Name, err)
+	}
+	return tmpl, nil
+}
+
+// bodyData is what a body template is given.
+type bodyData struct {
+	Target string
+	Event  event.Event // the first event of a batch
+	Events []event.Event
+}
+
+// body builds the request body for evts.
+func body(t TargetConfig, tmpl *template.Template, evts []event.Event) ([]byte, error) {
+	if tmpl != nil {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, bodyData{Target: t.Name, Event: evts[0], Events: evts})
+		return buf.Bytes(), err
+	}
+	if t.Batch > 1 {
+		return json.Marshal(evts)
+	}
+	return json.Marshal(evts[0])
+}
+
+// parseWeights parses name=weight pairs separated by commas, as in
+// kafka=70,eventbridge=30.
+func parseWeights(s string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	for _, pair := range strings.Split(s, ",") {
+		name, w, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not name=weight", pair)
+		}
+		v, err := strconv.ParseFloat(w, 64)
+		if err != nil {
+			return nil, fmt.Errorf("weight of %s: %w", name, err)
+		}
+		weights[name] = v
+	}
+	return weights, nil
+}
+
+// targetFlags collects repeated -target name=url flags.
+type targetFlags []TargetConfig
+
+func (f *targetFlags) String() string {
+	parts := make([]string, len(*f))
+	for i, t := range *f {
+		parts[i] = t.Name + "=" + t.URL
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *targetFlags) Set(s string) error {
+	name, url, ok := strings.Cut(s, "=")
+	if !ok || name == "" || url == "" {
+		return fmt.Errorf("%q is not name=url", s)
+	}
+	*f = append(*f, TargetConfig{Name: name, URL: url})
+	return nil
+}
+
+// mergeTargets adds the targets of the -target flags to those of the
+// -targets file. A flag gives the URL of a target the file also
+// configures.
+func mergeTargets(file []TargetConfig, flags []TargetConfig) []TargetConfig {
+	out := append([]TargetConfig(nil), file...)
+	for _, f := range flags {
+		found := false
+		for i := range out {
+			if out[i].Name == f.Name {
+				out[i].URL, found = f.URL, true
+			}
+		}
+		if !found {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// formatWeights writes weights as name=weight pairs in name order.
+func formatWeights(m map[string]float64) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%g", k, m[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+// expandHeaders returns the headers of t with environment variables
+// expanded, so secrets need not be written into the targets file.
+func expandHeaders(t TargetConfig) map[string]string {
+	out := make(map[string]string, len(t.Headers))
+	for k, v := range t.Headers {
+		out[k] = os.ExpandEnv(v)
+	}
+	return out
+}
+
+// expandTargetHeaders returns a copy of targets with environment variables
+// expanded in their headers, for sending to agents, which use headers as
+// they receive them.
+func expandTargetHeaders(targets []TargetConfig) []TargetConfig {
+	out := make([]TargetConfig, len(targets))
+	for i, t := range targets {
+		out[i] = t
+		out[i].Headers = expandHeaders(t)
+	}
+	return out
+}
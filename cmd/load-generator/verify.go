@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/config"
+	"github.com/Babatunde13/event-pipeline/internal/database"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// missingSample is how many missing event IDs a verification lists.
+const missingSample = 20
+
+// Verifier records every event the generator sends so that, once the run is
+// over, it can check which of them reached the sink.
+type Verifier struct {
+	mu   sync.Mutex
+	sent map[string]sentEvent // by event ID
+}
+
+type sentEvent struct {
+	target   string
+	accepted bool // the target acknowledged it
+}
+
+// checkVerifiable returns an error when the sink is configured with the
+// memory driver, whose events live only in the process that wrote them, so
+// verification would find none of them.
+func checkVerifiable(cfg *config.Config) error {
+	drivers := []string{cfg.DatabaseDriver}
+	if len(cfg.Sinks) > 0 {
+		drivers = drivers[:0]
+		for _, sc := range cfg.Sinks {
+			drivers = append(drivers, sc.Driver)
+		}
+	}
+	for _, d := range drivers {
+		if strings.EqualFold(d, "memory") {
+			return fmt.Errorf("cannot verify against the memory driver, which keeps events only in the consumer's process")
+		}
+	}
+	return nil
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{sent: map[string]sentEvent{}}
+}
+
+// Record notes an event sent to target and whether it was accepted.
+func (v *Verifier) Record(target, eventID string, accepted bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sent[eventID] = sentEvent{target: target, accepted: accepted}
+}
+
+// Verification is what the sink holds of the events sent, per target and
+// over all of them. End to end latency runs from the event's Timestamp to
+// its StoredAt.
+type Verification struct {
+	GraceS  float64          `json:"grace_s"`
+	Total   DeliveryReport   `json:"total"`
+	Targets []DeliveryReport `json:"targets"`
+	hists   map[string]*hdrhistogram.Histogram
+}
+
+// DeliveryReport counts what became of the events sent to one target.
+//
+// Duplicated counts events stored under more than one key. With the event
+// key layout a redelivery has the same event_id and timestamp as the first
+// delivery and overwrites it, so Duplicated stays at about zero whatever
+// the broker redelivered; for the same reason StoredAt, and so EndToEnd,
+// reflects the last write of an event rather than the first.
+type DeliveryReport struct {
+	Name          string    `json:"name"`
+	Accepted      int64     `json:"accepted"`   // acknowledged by the target
+	Delivered     int64     `json:"delivered"`  // accepted and found in the sink
+	Missing       int64     `json:"missing"`    // accepted and not found
+	Duplicated    int64     `json:"duplicated"` // found more than once
+	Unexpected    int64     `json:"unexpected"` // rejected, yet found
+	ReadErrors    int64     `json:"read_errors"`
+	EndToEnd      Latency   `json:"end_to_end"`
+	Histogram     Histogram `json:"histogram"`
+	MissingSample []string  `json:"missing_sample,omitempty"`
+}
+
+// Verify looks up every recorded event in db with the given number of
+// concurrent readers. Targets are reported in the order of names.
+func (v *Verifier) Verify(ctx context.Context, db database.Reader, names []string, workers int, grace time.Duration) *Verification {
+	v.mu.Lock()
+	ids := make(chan string, len(v.sent))
+	for id := range v.sent {
+		ids <- id
+	}
+	close(ids)
+	total := len(v.sent)
+	v.mu.Unlock()
+
+	res := &Verification{GraceS: grace.Seconds(), hists: map[string]*hdrhistogram.Histogram{}}
+	byTarget := map[string]*DeliveryReport{}
+	var mu sync.Mutex
+	checked := 0
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				copies, err := event.GetAll(ctx, db, id)
+
+				mu.Lock()
+				sent := v.sent[id]
+				r, ok := byTarget[sent.target]
+				if !ok {
+					r = &DeliveryReport{Name: sent.target}
+					byTarget[sent.target] = r
+					res.hists[sent.target] = newHistogram()
+				}
+				res.record(r, id, sent, copies, err)
+				checked++
+				if checked%10000 == 0 {
+					logging.Info(ctx, "verifying", "checked", checked, "of", total)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	all := newHistogram()
+	res.Total.Name = "all"
+	for _, name := range names {
+		r, ok := byTarget[name]
+		if !ok {
+			continue
+		}
+		h := res.hists[name]
+		r.EndToEnd = summarise(h)
+		r.Histogram = exportHistogram(h)
+		all.Merge(h)
+		res.Targets = append(res.Targets, *r)
+
+		res.Total.Accepted += r.Accepted
+		res.Total.Delivered += r.Delivered
+		res.Total.Missing += r.Missing
+		res.Total.Duplicated += r.Duplicated
+		res.Total.Unexpected += r.Unexpected
+		res.Total.ReadErrors += r.ReadErrors
+	}
+	res.Total.EndToEnd = summarise(all)
+	return res
+}
+
+func (res *Verification) record(r *DeliveryReport, id string, sent sentEvent, copies []event.Event, err error) {
+	if sent.accepted {
+		r.Accepted++
+	}
+	switch {
+	case err != nil:
+		r.ReadErrors++
+		return
+	case len(copies) == 0:
+		if sent.accepted {
+			r.Missing++
+			if len(r.MissingSample) < missingSample {
+				r.MissingSample = append(r.MissingSample, id)
+			}
+		}
+		return
+	case !sent.accepted:
+		r.Unexpected++
+	default:
+		r.Delivered++
+	}
+	if len(copies) > 1 {
+		r.Duplicated++
+	}
+
+	// The first copy stored is the one that made it through the pipeline,
+	// though a copy that was overwritten carries its last StoredAt.
+	first := copies[0]
+	for _, c := range copies[1:] {
+		if c.StoredAt != 0 && (first.StoredAt == 0 || c.StoredAt < first.StoredAt) {
+			first = c
+		}
+	}
+	if first.Timestamp != 0 && first.StoredAt != 0 {
+		res.hists[r.Name].RecordValue(clampLatency(event.Between(first.Timestamp, first.StoredAt)))
+	}
+}
+
+// Print writes the verification to the summary.
+func (res *Verification) Print() {
+	fmt.Println("====== Delivery ======")
+	for _, r := range append(res.Targets, res.Total) {
+		fmt.Printf("%-12s accepted %d, delivered %d (%s), missing %d, duplicated %d, unexpected %d, read errors %d\n",
+			r.Name+":", r.Accepted, r.Delivered, percent(r.Delivered, r.Accepted), r.Missing, r.Duplicated, r.Unexpected, r.ReadErrors)
+		if r.EndToEnd.Count > 0 {
+			fmt.Printf("%-12s end to end p50=%s p90=%s p99=%s p99.9=%s max=%s\n", "",
+				usString(r.EndToEnd.P50), usString(r.EndToEnd.P90), usString(r.EndToEnd.P99), usString(r.EndToEnd.P999), usString(r.EndToEnd.Max))
+		}
+	}
+}
+
+func percent(part, total int64) string {
+	if total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.2f%%", 100*float64(part)/float64(total))
+}
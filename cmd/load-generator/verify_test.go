@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Babatunde13/event-pipeline/internal/config"
+)
+
+func TestCheckVerifiable(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{name: "default driver", cfg: config.Config{}},
+		{name: "sqlite", cfg: config.Config{DatabaseDriver: "sqlite"}},
+		{name: "memory", cfg: config.Config{DatabaseDriver: "memory"}, wantErr: true},
+		{name: "memory sink", cfg: config.Config{Sinks: []config.SinkConfig{{Driver: "dynamodb"}, {Driver: "Memory"}}}, wantErr: true},
+		{name: "sinks replace the driver", cfg: config.Config{DatabaseDriver: "memory", Sinks: []config.SinkConfig{{Driver: "postgres"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkVerifiable(&tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
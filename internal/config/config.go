@@ -3,21 +3,53 @@ package config
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Babatunde13/event-pipeline/internal/logging"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	secretsmanager "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 )
 
+// SinkConfig is one entry of SINKS, a destination processed events are
+// written to.
+type SinkConfig struct {
+	Name     string `json:"name,omitempty"`     // in errors and logs; default the driver, numbered when it is used twice
+	Driver   string `json:"driver"`             // dynamodb, postgres, parquet, sqlite or memory
+	Optional bool   `json:"optional,omitempty"` // failures are logged instead of failing the event
+	Order    int    `json:"order,omitempty"`    // lower orders are written first, equal orders concurrently
+	Timeout  string `json:"timeout,omitempty"`  // per write, e.g. 500ms
+}
+
 type Config struct {
-	Brokers                  string `json:"KAFKA_BROKERS"`
-	KafkaTopic               string `json:"KAFKA_TOPIC"`
-	EventBusName             string `json:"EVENT_BUS_NAME"`
-	EventBusSource           string `json:"EVENT_BUS_SOURCE"`
-	PrometheusPushGatewayUrl string `json:"PROMETHEUS_PUSH_GATEWAY_URL"`
-	CaCert                   string `json:"KAFKA_CA_CERT"`
+	Brokers                  string       `json:"KAFKA_BROKERS"`
+	KafkaTopic               string       `json:"KAFKA_TOPIC"`
+	KafkaConsumerGroup       string       `json:"KAFKA_CONSUMER_GROUP"` // group of the kafka consumer's event source mapping, for lag
+	EventBusName             string       `json:"EVENT_BUS_NAME"`
+	EventBusSource           string       `json:"EVENT_BUS_SOURCE"`
+	PrometheusPushGatewayUrl string       `json:"PROMETHEUS_PUSH_GATEWAY_URL"`
+	MetricsFlushInterval     string       `json:"METRICS_FLUSH_INTERVAL"`      // e.g. 10s
+	LogLevel                 string       `json:"LOG_LEVEL"`                   // debug, info (default), warn or error
+	LogSampleRate            string       `json:"LOG_SAMPLE_RATE"`             // share of per-event log lines kept, 0 < rate <= 1; default 1
+	OtelEndpoint             string       `json:"OTEL_EXPORTER_OTLP_ENDPOINT"` // OTLP/HTTP collector, e.g. http://localhost:4318; empty disables export
+	CaCert                   string       `json:"KAFKA_CA_CERT"`
+	DatabaseDriver           string       `json:"DATABASE_DRIVER"` // dynamodb (default), postgres, sqlite or memory
+	Sinks                    []SinkConfig `json:"SINKS"`           // when set, replaces DATABASE_DRIVER
+	SQLitePath               string       `json:"SQLITE_PATH"`
+	PostgresDSN              string       `json:"POSTGRES_DSN"`
+	EventsTable              string       `json:"EVENTS_TABLE"`
+	KafkaEventsTable         string       `json:"KAFKA_EVENTS_TABLE"`       // overrides EVENTS_TABLE for Kafka events
+	EventBridgeEventsTable   string       `json:"EVENTBRIDGE_EVENTS_TABLE"` // overrides EVENTS_TABLE for EventBridge events
+	EventsTTL                string       `json:"EVENTS_TTL"`               // e.g. 720h, sets expires_at; empty keeps events forever
+	EventsKeyLayout          string       `json:"EVENTS_KEY_LAYOUT"`        // event (default) or user, which postgres does not support
+	ArchiveDir               string       `json:"ARCHIVE_DIR"`              // parquet sink directory, when no bucket is set
+	ArchiveBucket            string       `json:"ARCHIVE_BUCKET"`
+	ArchivePrefix            string       `json:"ARCHIVE_PREFIX"`
+	ArchiveEndpoint          string       `json:"ARCHIVE_ENDPOINT"` // S3-compatible endpoint, e.g. MinIO
+	ArchiveMaxRecords        string       `json:"ARCHIVE_MAX_RECORDS"`
+	ArchiveMaxAge            string       `json:"ARCHIVE_MAX_AGE"` // e.g. 1m
 	KafkaCaCertPath          string
 	AwsConfig                *aws.Config
 	KafkaBrokers             []string
@@ -25,6 +57,39 @@ type Config struct {
 
 var Cfg Config
 
+const defaultMetricsFlushInterval = 10 * time.Second
+
+// MetricsInterval is how often buffered metrics are pushed, 10s unless
+// METRICS_FLUSH_INTERVAL says otherwise.
+func (c *Config) MetricsInterval() time.Duration {
+	d, err := time.ParseDuration(c.MetricsFlushInterval)
+	if err != nil || d <= 0 {
+		return defaultMetricsFlushInterval
+	}
+	return d
+}
+
+// SampleRate is the share of per-event log lines kept, all of them unless
+// LOG_SAMPLE_RATE is between 0 and 1.
+func (c *Config) SampleRate() float64 {
+	rate, err := strconv.ParseFloat(c.LogSampleRate, 64)
+	if err != nil || rate <= 0 || rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// ArchiveRecords is how many events the parquet sink buffers before it
+// flushes, or 0, which keeps the archive's default, when
+// ARCHIVE_MAX_RECORDS is not a positive number.
+func (c *Config) ArchiveRecords() int {
+	n, err := strconv.Atoi(c.ArchiveMaxRecords)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
 func parseCaCert(cert string) string {
 	// Remove any leading or trailing whitespace
 	cert = strings.TrimSpace(cert)
@@ -35,7 +100,7 @@ func parseCaCert(cert string) string {
 	// Extract the middle base64 part
 	parts := strings.Split(cert, "\n")
 	if len(parts) < 2 {
-		log.Fatal("Invalid certificate format")
+		logging.Fatal(context.Background(), "invalid certificate format")
 	}
 
 	begin := parts[0]
@@ -60,7 +125,7 @@ func Load(secretName string) {
 
 	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-2"))
 	if err != nil {
-		log.Fatalf("unable to load AWS config: %v", err)
+		logging.Fatal(ctx, "unable to load AWS config", logging.Err(err))
 	}
 
 	smClient := secretsmanager.NewFromConfig(awsCfg)
@@ -68,12 +133,12 @@ func Load(secretName string) {
 		SecretId: aws.String(secretName),
 	})
 	if err != nil {
-		log.Fatalf("unable to retrieve secrets: %v", err)
+		logging.Fatal(ctx, "unable to retrieve secrets", "secret", secretName, logging.Err(err))
 	}
 
 	err = json.Unmarshal([]byte(*resp.SecretString), &Cfg)
 	if err != nil {
-		log.Fatalf("unable to unmarshal secrets: %v", err)
+		logging.Fatal(ctx, "unable to unmarshal secrets", "secret", secretName, logging.Err(err))
 	}
 
 	Cfg.AwsConfig = &awsCfg
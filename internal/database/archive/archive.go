@@ -0,0 +1,334 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	defaultMaxRecords = 10000
+	defaultMaxAge     = time.Minute
+)
+
+// Options configures where the archive writes and how often it flushes.
+type Options struct {
+	Dir        string        // local directory, used when Bucket is empty
+	Bucket     string        // S3 bucket
+	Prefix     string        // key prefix inside Dir or Bucket
+	Endpoint   string        // S3-compatible endpoint, e.g. a local MinIO
+	MaxRecords int           // flush once this many events are buffered
+	MaxAge     time.Duration // flush once the oldest buffered event is this old
+}
+
+// record is the columnar layout of an archived event. Metadata fields every
+// event type carries get their own column; anything else stays as JSON.
+type record struct {
+	EventID   string   `parquet:"event_id"`
+	Timestamp int64    `parquet:"timestamp,timestamp(millisecond:utc)"`
+	EventType string   `parquet:"event_type,dict"`
+	UserID    string   `parquet:"user_id"`
+	Source    string   `parquet:"source,dict"`
+	ProductID *string  `parquet:"product_id,optional,dict"`
+	Price     *float64 `parquet:"price,optional"`
+	Metadata  string   `parquet:"metadata,optional"`
+}
+
+// Archive is a write-only sink that buffers events and writes them as
+// Parquet files partitioned by event time and source, under
+// dt=YYYY-MM-DD/hour=HH/source=<source>/. The table name passed to writes
+// is ignored, and Create cannot detect duplicates, so it behaves like Save.
+//
+// A successful write only means the event is buffered. It is durable once
+// Flush returns nil, so callers that acknowledge events upstream, or that
+// can be frozen between requests like a Lambda, must Flush before doing so.
+// The consumers flush at the end of every invocation, so in Lambda a file
+// holds at most one invocation's events and MaxRecords and MaxAge only
+// matter to long-running writers.
+type Archive struct {
+	opts  Options
+	store objectStore
+
+	mu      sync.Mutex
+	buffer  map[string][]record // by partition directory
+	count   int
+	oldest  time.Time
+	flushMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns an archive writing to opts.Bucket through awsCfg, or to
+// opts.Dir when no bucket is set, and starts its age-based flushing.
+func New(opts Options, awsCfg *aws.Config) (*Archive, error) {
+	if opts.MaxRecords <= 0 {
+		opts.MaxRecords = defaultMaxRecords
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultMaxAge
+	}
+
+	var store objectStore
+	switch {
+	case opts.Bucket != "":
+		if awsCfg == nil {
+			return nil, fmt.Errorf("archive: bucket %s needs an AWS config", opts.Bucket)
+		}
+		client := s3.NewFromConfig(*awsCfg, func(o *s3.Options) {
+			if opts.Endpoint != "" {
+				o.BaseEndpoint = aws.String(opts.Endpoint)
+				o.UsePathStyle = true
+			}
+		})
+		store = &s3Store{client: client, bucket: opts.Bucket}
+	case opts.Dir != "":
+		if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+			return nil, err
+		}
+		store = &dirStore{dir: opts.Dir}
+	default:
+		return nil, fmt.Errorf("archive: either a directory or a bucket is required")
+	}
+
+	a := &Archive{
+		opts:   opts,
+		store:  store,
+		buffer: map[string][]record{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go a.flushLoop()
+	return a, nil
+}
+
+func (a *Archive) Save(ctx context.Context, tableName string, item interface{}) error {
+	errs := a.SaveBatch(ctx, tableName, []interface{}{item})
+	return errs[0]
+}
+
+func (a *Archive) Create(ctx context.Context, tableName string, item interface{}) error {
+	return a.Save(ctx, tableName, item)
+}
+
+// SaveBatch buffers the events and flushes when the buffer is full or has
+// aged out. A failed flush keeps the events buffered for the next attempt;
+// it is logged rather than reported against this batch, since the events
+// it failed to write may have come from earlier ones.
+func (a *Archive) SaveBatch(ctx context.Context, tableName string, items []interface{}) []error {
+	errs := make([]error, len(items))
+	a.mu.Lock()
+	for i, item := range items {
+		r, err := toRecord(item)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if a.count == 0 {
+			a.oldest = time.Now()
+		}
+		dir := partition(r)
+		a.buffer[dir] = append(a.buffer[dir], r)
+		a.count++
+	}
+	due := a.dueLocked()
+	a.mu.Unlock()
+
+	if due {
+		if err := a.Flush(ctx); err != nil {
+			logging.Error(ctx, "archive flush failed", logging.Err(err))
+		}
+	}
+	return errs
+}
+
+func (a *Archive) dueLocked() bool {
+	return a.count >= a.opts.MaxRecords || (a.count > 0 && time.Since(a.oldest) >= a.opts.MaxAge)
+}
+
+// Flush writes every buffered event, one file per partition. Events it
+// fails to write stay buffered for the next flush.
+func (a *Archive) Flush(ctx context.Context) error {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	a.mu.Lock()
+	pending := a.buffer
+	a.buffer = map[string][]record{}
+	a.count = 0
+	a.mu.Unlock()
+
+	dirs := make([]string, 0, len(pending))
+	for dir := range pending {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var firstErr error
+	for _, dir := range dirs {
+		err := a.writeFile(ctx, dir, pending[dir])
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		a.mu.Lock()
+		if a.count == 0 {
+			a.oldest = time.Now()
+		}
+		a.buffer[dir] = append(pending[dir], a.buffer[dir]...)
+		a.count += len(pending[dir])
+		a.mu.Unlock()
+	}
+	return firstErr
+}
+
+// Close stops the background flushing and writes whatever is left.
+func (a *Archive) Close() error {
+	close(a.stop)
+	<-a.done
+	return a.Flush(context.Background())
+}
+
+func (a *Archive) flushLoop() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.opts.MaxAge / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			due := a.dueLocked()
+			a.mu.Unlock()
+			if due {
+				if err := a.Flush(context.Background()); err != nil {
+					logging.Error(context.Background(), "archive flush failed", logging.Err(err))
+				}
+			}
+		}
+	}
+}
+
+func (a *Archive) writeFile(ctx context.Context, dir string, records []record) error {
+	sort.Slice(records, func(i, j int) bool { return records[i].Timestamp < records[j].Timestamp })
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[record](&buf, parquet.Compression(&parquet.Snappy))
+	if _, err := w.Write(records); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("part-%d-%s.parquet", time.Now().UnixNano(), uuid.NewString()[:8])
+	key := path.Join(a.opts.Prefix, dir, name)
+	return a.store.put(ctx, key, buf.Bytes())
+}
+
+func partition(r record) string {
+	t := time.UnixMilli(r.Timestamp).UTC()
+	source := r.Source
+	if source == "" {
+		source = "unknown"
+	}
+	return fmt.Sprintf("dt=%s/hour=%02d/source=%s", t.Format("2006-01-02"), t.Hour(), source)
+}
+
+func toRecord(item interface{}) (record, error) {
+	var e *event.Event
+	switch v := item.(type) {
+	case *event.Event:
+		e = v
+	case event.Event:
+		e = &v
+	default:
+		return record{}, fmt.Errorf("archive: cannot store %T, only event.Event", item)
+	}
+
+	r := record{
+		EventID:   e.EventID,
+		Timestamp: e.Timestamp,
+		EventType: string(e.EventType),
+		UserID:    e.UserID,
+		Source:    string(e.Source),
+	}
+	rest := map[string]interface{}{}
+	for k, v := range e.Metadata {
+		switch k {
+		case "product_id":
+			if s, ok := v.(string); ok {
+				r.ProductID = &s
+				continue
+			}
+		case "price":
+			if f, ok := v.(float64); ok {
+				r.Price = &f
+				continue
+			}
+		}
+		rest[k] = v
+	}
+	if len(rest) > 0 {
+		data, err := json.Marshal(rest)
+		if err != nil {
+			return record{}, err
+		}
+		r.Metadata = string(data)
+	}
+	return r, nil
+}
+
+type objectStore interface {
+	put(ctx context.Context, key string, data []byte) error
+}
+
+type dirStore struct {
+	dir string
+}
+
+// put writes through a temporary file so readers never see half a file.
+func (s *dirStore) put(ctx context.Context, key string, data []byte) error {
+	target := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func (s *s3Store) put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(strings.TrimPrefix(key, "/")),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/vnd.apache.parquet"),
+	})
+	return err
+}
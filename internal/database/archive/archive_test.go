@@ -0,0 +1,239 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/parquet-go/parquet-go"
+)
+
+var testTime = time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)
+
+// newTestArchive returns an archive writing to a temporary directory, which
+// it also returns. Unless opts says otherwise it flushes only when asked.
+func newTestArchive(t *testing.T, opts Options) (*Archive, string) {
+	t.Helper()
+	opts.Dir = t.TempDir()
+	if opts.MaxRecords == 0 {
+		opts.MaxRecords = 1000
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = time.Hour
+	}
+	a, err := New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a, opts.Dir
+}
+
+// readArchive returns the records of every Parquet file under dir by the
+// partition directory they were written to.
+func readArchive(t *testing.T, dir string) map[string][]record {
+	t.Helper()
+	out := map[string][]record{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !strings.HasSuffix(p, ".parquet") {
+			t.Errorf("unexpected file %s", p)
+			return nil
+		}
+		records, err := parquet.ReadFile[record](p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, filepath.Dir(p))
+		out[filepath.ToSlash(rel)] = append(out[filepath.ToSlash(rel)], records...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func testEvent(id string, at time.Time, source event.EventSource) event.Event {
+	return event.Event{
+		EventID:   id,
+		Timestamp: at.UnixMilli(),
+		EventType: event.ViewProduct,
+		UserID:    "u1",
+		Source:    source,
+		Metadata:  map[string]interface{}{"product_id": "p1"},
+	}
+}
+
+func save(t *testing.T, a *Archive, evts ...event.Event) {
+	t.Helper()
+	items := make([]interface{}, len(evts))
+	for i, e := range evts {
+		items[i] = e
+	}
+	for i, err := range a.SaveBatch(context.Background(), "ignored", items) {
+		if err != nil {
+			t.Fatalf("save event %d: %v", i, err)
+		}
+	}
+}
+
+func ids(records []record) []string {
+	out := make([]string, len(records))
+	for i, r := range records {
+		out[i] = r.EventID
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestFlushPartitions(t *testing.T) {
+	a, dir := newTestArchive(t, Options{Prefix: "events"})
+	save(t, a,
+		testEvent("a", testTime, event.SourceKafka),
+		testEvent("b", testTime.Add(time.Minute), event.SourceKafka),
+		testEvent("c", testTime.Add(time.Hour), event.SourceKafka),
+		testEvent("d", testTime, event.SourceEventBridge),
+		testEvent("e", testTime, ""),
+	)
+	if got := readArchive(t, dir); len(got) != 0 {
+		t.Fatalf("wrote %v before a flush", got)
+	}
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]string{}
+	for part, records := range readArchive(t, dir) {
+		got[part] = ids(records)
+	}
+	want := map[string][]string{
+		"events/dt=2025-03-01/hour=09/source=kafka":       {"a", "b"},
+		"events/dt=2025-03-01/hour=10/source=kafka":       {"c"},
+		"events/dt=2025-03-01/hour=09/source=eventbridge": {"d"},
+		"events/dt=2025-03-01/hour=09/source=unknown":     {"e"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("partitions\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestRecordColumns(t *testing.T) {
+	a, dir := newTestArchive(t, Options{})
+	e := testEvent("a", testTime, event.SourceKafka)
+	e.Metadata = map[string]interface{}{"product_id": "p1", "price": 9.5, "quantity": 2.0}
+	odd := testEvent("b", testTime, event.SourceKafka)
+	odd.Metadata = map[string]interface{}{"product_id": 7.0}
+	save(t, a, e, odd)
+	if errs := a.SaveBatch(context.Background(), "ignored", []interface{}{"not an event"}); errs[0] == nil {
+		t.Error("archived a value that is not an event")
+	}
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []record
+	for _, rs := range readArchive(t, dir) {
+		records = append(records, rs...)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].EventID < records[j].EventID })
+	if len(records) != 2 {
+		t.Fatalf("read %d records, want 2", len(records))
+	}
+	r := records[0]
+	if r.Timestamp != e.Timestamp || r.EventType != string(event.ViewProduct) || r.UserID != "u1" || r.Source != string(event.SourceKafka) {
+		t.Errorf("record %+v does not match its event", r)
+	}
+	if r.ProductID == nil || *r.ProductID != "p1" || r.Price == nil || *r.Price != 9.5 {
+		t.Errorf("product_id and price not in their own columns: %+v", r)
+	}
+	if r.Metadata != `{"quantity":2}` {
+		t.Errorf("metadata %q, want the remaining fields as JSON", r.Metadata)
+	}
+	// A product ID that is not a string stays in the metadata.
+	if o := records[1]; o.ProductID != nil || o.Metadata != `{"product_id":7}` {
+		t.Errorf("record %+v, want its product_id left in the metadata", o)
+	}
+}
+
+func TestSizeFlush(t *testing.T) {
+	a, dir := newTestArchive(t, Options{MaxRecords: 3})
+	save(t, a, testEvent("a", testTime, event.SourceKafka), testEvent("b", testTime, event.SourceKafka))
+	if got := readArchive(t, dir); len(got) != 0 {
+		t.Fatalf("flushed %v below MaxRecords", got)
+	}
+	save(t, a, testEvent("c", testTime, event.SourceKafka))
+	got := readArchive(t, dir)
+	if len(got) != 1 {
+		t.Fatalf("wrote %d partitions, want 1", len(got))
+	}
+	for _, records := range got {
+		if ids := ids(records); !reflect.DeepEqual(ids, []string{"a", "b", "c"}) {
+			t.Errorf("flushed %v, want a, b and c", ids)
+		}
+	}
+}
+
+func TestAgeFlush(t *testing.T) {
+	a, dir := newTestArchive(t, Options{MaxAge: 20 * time.Millisecond})
+	save(t, a, testEvent("a", testTime, event.SourceKafka))
+	deadline := time.Now().Add(2 * time.Second)
+	for len(readArchive(t, dir)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("buffered event not flushed after MaxAge")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failingStore fails every put while fail is set.
+type failingStore struct {
+	objectStore
+	fail bool
+}
+
+func (s *failingStore) put(ctx context.Context, key string, data []byte) error {
+	if s.fail {
+		return errors.New("store unavailable")
+	}
+	return s.objectStore.put(ctx, key, data)
+}
+
+func TestFailedFlushKeepsEvents(t *testing.T) {
+	a, dir := newTestArchive(t, Options{})
+	store := &failingStore{objectStore: a.store, fail: true}
+	a.store = store
+	save(t, a, testEvent("a", testTime, event.SourceKafka), testEvent("b", testTime, event.SourceEventBridge))
+
+	if err := a.Flush(context.Background()); err == nil {
+		t.Fatal("flush succeeded with the store failing")
+	}
+	if a.count != 2 {
+		t.Errorf("%d events buffered after the failed flush, want 2", a.count)
+	}
+	save(t, a, testEvent("c", testTime, event.SourceKafka))
+
+	store.fail = false
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var all []record
+	for _, records := range readArchive(t, dir) {
+		all = append(all, records...)
+	}
+	if got := ids(all); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("archived %v, want a, b and c once each", got)
+	}
+	if a.count != 0 {
+		t.Errorf("%d events still buffered", a.count)
+	}
+}
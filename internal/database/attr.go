@@ -0,0 +1,86 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// KeySchema names the attributes making up a table's primary key. SortKey
+// is empty for tables keyed by partition key alone.
+type KeySchema struct {
+	PartitionKey string
+	SortKey      string
+}
+
+// keyString renders a scalar attribute value so that two values produce the
+// same string exactly when DynamoDB would treat them as the same key.
+func keyString(av types.AttributeValue) string {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return "S:" + v.Value
+	case *types.AttributeValueMemberN:
+		n, ok := new(big.Float).SetString(v.Value)
+		if !ok {
+			return "N:" + v.Value
+		}
+		return "N:" + n.Text('g', -1)
+	case *types.AttributeValueMemberB:
+		return "B:" + base64.StdEncoding.EncodeToString(v.Value)
+	default:
+		return ""
+	}
+}
+
+// itemKey returns the storage key of item under schema.
+func itemKey(item map[string]types.AttributeValue, schema KeySchema) (string, error) {
+	pk, ok := item[schema.PartitionKey]
+	if !ok || keyString(pk) == "" {
+		return "", fmt.Errorf("item is missing key attribute %q", schema.PartitionKey)
+	}
+	if schema.SortKey == "" {
+		return keyString(pk), nil
+	}
+	sk, ok := item[schema.SortKey]
+	if !ok || keyString(sk) == "" {
+		return "", fmt.Errorf("item is missing key attribute %q", schema.SortKey)
+	}
+	return keyString(pk) + "|" + keyString(sk), nil
+}
+
+// compareValues orders two scalar attribute values of the same type the way
+// DynamoDB orders sort keys. ok is false when the values are not comparable.
+func compareValues(a, b types.AttributeValue) (cmp int, ok bool) {
+	switch x := a.(type) {
+	case *types.AttributeValueMemberS:
+		if y, isS := b.(*types.AttributeValueMemberS); isS {
+			return strings.Compare(x.Value, y.Value), true
+		}
+	case *types.AttributeValueMemberN:
+		if y, isN := b.(*types.AttributeValueMemberN); isN {
+			nx, okx := new(big.Float).SetString(x.Value)
+			ny, oky := new(big.Float).SetString(y.Value)
+			if okx && oky {
+				return nx.Cmp(ny), true
+			}
+		}
+	case *types.AttributeValueMemberB:
+		if y, isB := b.(*types.AttributeValueMemberB); isB {
+			return bytes.Compare(x.Value, y.Value), true
+		}
+	case *types.AttributeValueMemberBOOL:
+		if y, isBool := b.(*types.AttributeValueMemberBOOL); isBool && x.Value == y.Value {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func equalValues(a, b types.AttributeValue) bool {
+	cmp, ok := compareValues(a, b)
+	return ok && cmp == 0
+}
@@ -1,7 +1,70 @@
 package database
 
-import "context"
+import (
+	"context"
+	"errors"
+)
 
-type Database interface {
+var (
+	// ErrNotFound is returned when a lookup matches no item.
+	ErrNotFound = errors.New("item not found")
+	// ErrAlreadyExists is returned by Create when an item with the same
+	// primary key is already stored.
+	ErrAlreadyExists = errors.New("item already exists")
+)
+
+// Query selects items by partition key and an optional, inclusive sort key
+// range, either from the table itself or from one of its secondary indexes.
+type Query struct {
+	Index          string
+	PartitionKey   string
+	PartitionValue interface{}
+	SortKey        string
+	SortFrom       interface{} // no lower bound when nil
+	SortTo         interface{} // no upper bound when nil
+	Limit          int32
+	Cursor         string
+}
+
+// Scan reads a whole table page by page, keeping the items whose attributes
+// equal every value in Filter.
+type Scan struct {
+	Filter map[string]interface{}
+	Limit  int32
+	Cursor string
+}
+
+type Writer interface {
 	Save(ctx context.Context, tableName string, item interface{}) error
+	// Create writes item only if no item with the same primary key exists.
+	Create(ctx context.Context, tableName string, item interface{}) error
+	// SaveBatch writes items to tableName and returns one error per item, in
+	// the same order as items. A nil entry means the item was written.
+	SaveBatch(ctx context.Context, tableName string, items []interface{}) []error
+}
+
+// Reader decodes matching items into out, which must be a pointer to a slice.
+// The returned cursor continues the read on the next call and is empty once
+// there are no more pages.
+type Reader interface {
+	Query(ctx context.Context, tableName string, q Query, out interface{}) (string, error)
+	Scan(ctx context.Context, tableName string, s Scan, out interface{}) (string, error)
+}
+
+type Database interface {
+	Writer
+	Reader
+}
+
+// TableCreator is implemented by databases whose tables are created by the
+// application rather than provisioned ahead of time. CreateTable does nothing
+// when the table already exists.
+type TableCreator interface {
+	CreateTable(ctx context.Context, tableName string, key KeySchema) error
+}
+
+// Flusher is implemented by sinks that buffer writes. Flush writes out
+// everything buffered so far.
+type Flusher interface {
+	Flush(ctx context.Context) error
 }
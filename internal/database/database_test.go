@@ -0,0 +1,251 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testItem struct {
+	ID   string `dynamodbav:"id"`
+	TS   int64  `dynamodbav:"ts"`
+	User string `dynamodbav:"user,omitempty"`
+	Kind string `dynamodbav:"kind,omitempty"`
+}
+
+var testSchema = KeySchema{PartitionKey: "id", SortKey: "ts"}
+
+// databases returns every Database that runs offline, each with an empty
+// "items" table keyed by testSchema.
+func databases(t *testing.T) map[string]Database {
+	t.Helper()
+	sqlite, err := NewSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	dbs := map[string]Database{"memory": NewMemory(), "sqlite": sqlite}
+	for name, db := range dbs {
+		if err := db.(TableCreator).CreateTable(context.Background(), "items", testSchema); err != nil {
+			t.Fatalf("%s: create table: %v", name, err)
+		}
+	}
+	return dbs
+}
+
+func seed(t *testing.T, db Database, items ...testItem) {
+	t.Helper()
+	data := make([]interface{}, len(items))
+	for i, it := range items {
+		data[i] = it
+	}
+	for i, err := range db.SaveBatch(context.Background(), "items", data) {
+		if err != nil {
+			t.Fatalf("seed item %d: %v", i, err)
+		}
+	}
+}
+
+func ids(items []testItem) []string {
+	out := make([]string, len(items))
+	for i, it := range items {
+		out[i] = it.ID
+	}
+	return out
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	for name, db := range databases(t) {
+		t.Run(name, func(t *testing.T) {
+			steps := []struct {
+				name string
+				op   func() error
+				want error
+			}{
+				{"new key", func() error { return db.Create(ctx, "items", testItem{ID: "a", TS: 1}) }, nil},
+				{"same key", func() error { return db.Create(ctx, "items", testItem{ID: "a", TS: 1, Kind: "x"}) }, ErrAlreadyExists},
+				{"other sort key", func() error { return db.Create(ctx, "items", testItem{ID: "a", TS: 2}) }, nil},
+				{"save overwrites", func() error { return db.Save(ctx, "items", testItem{ID: "a", TS: 1, Kind: "y"}) }, nil},
+			}
+			for _, s := range steps {
+				if err := s.op(); !errors.Is(err, s.want) {
+					t.Fatalf("%s: got %v, want %v", s.name, err, s.want)
+				}
+			}
+
+			var got []testItem
+			if _, err := db.Query(ctx, "items", Query{PartitionKey: "id", PartitionValue: "a", SortKey: "ts"}, &got); err != nil {
+				t.Fatal(err)
+			}
+			want := []testItem{{ID: "a", TS: 1, Kind: "y"}, {ID: "a", TS: 2}}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("stored %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSaveBatch(t *testing.T) {
+	ctx := context.Background()
+	for name, db := range databases(t) {
+		t.Run(name, func(t *testing.T) {
+			errs := db.SaveBatch(ctx, "items", []interface{}{
+				testItem{ID: "a", TS: 1},
+				map[string]interface{}{"ts": 2}, // no partition key
+				testItem{ID: "b", TS: 1},
+			})
+			if len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] != nil {
+				t.Fatalf("errors %v, want only the second item to fail", errs)
+			}
+			if errs := db.SaveBatch(ctx, "missing", []interface{}{testItem{ID: "a", TS: 1}}); errs[0] == nil {
+				t.Fatal("saving to a missing table succeeded")
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	items := []testItem{
+		{ID: "e3", TS: 30, User: "u1"},
+		{ID: "e1", TS: 10, User: "u1"},
+		{ID: "e2", TS: 20, User: "u2"},
+		{ID: "e4", TS: 40, User: "u1"},
+		{ID: "e5", TS: 50},
+	}
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"by key", Query{PartitionKey: "id", PartitionValue: "e2"}, []string{"e2"}},
+		{"no match", Query{PartitionKey: "id", PartitionValue: "nope"}, []string{}},
+		{"index in sort order", Query{Index: "user-ts", PartitionKey: "user", PartitionValue: "u1", SortKey: "ts"}, []string{"e1", "e3", "e4"}},
+		{"from", Query{Index: "user-ts", PartitionKey: "user", PartitionValue: "u1", SortKey: "ts", SortFrom: 30}, []string{"e3", "e4"}},
+		{"to", Query{Index: "user-ts", PartitionKey: "user", PartitionValue: "u1", SortKey: "ts", SortTo: 30}, []string{"e1", "e3"}},
+		{"between", Query{Index: "user-ts", PartitionKey: "user", PartitionValue: "u1", SortKey: "ts", SortFrom: 11, SortTo: 39}, []string{"e3"}},
+	}
+	for name, db := range databases(t) {
+		seed(t, db, items...)
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				var got []testItem
+				next, err := db.Query(ctx, "items", tt.q, &got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if next != "" {
+					t.Errorf("cursor %q without a limit", next)
+				}
+				if g := ids(got); !reflect.DeepEqual(g, tt.want) {
+					t.Errorf("got %v, want %v", g, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestQueryPaging(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		items int
+		limit int32
+		pages [][]string
+	}{
+		{"partial last page", 5, 2, [][]string{{"e1", "e2"}, {"e3", "e4"}, {"e5"}}},
+		// A full page always carries a cursor, so an exact fit ends with an
+		// empty page.
+		{"exact fit", 4, 2, [][]string{{"e1", "e2"}, {"e3", "e4"}, {}}},
+		{"one page", 3, 10, [][]string{{"e1", "e2", "e3"}}},
+	}
+	for _, tt := range tests {
+		for name, db := range databases(t) {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				for i := 1; i <= tt.items; i++ {
+					seed(t, db, testItem{ID: "e" + string(rune('0'+i)), TS: int64(i), User: "u1"})
+				}
+				q := Query{Index: "user-ts", PartitionKey: "user", PartitionValue: "u1", SortKey: "ts", Limit: tt.limit}
+				var pages [][]string
+				for {
+					var got []testItem
+					next, err := db.Query(ctx, "items", q, &got)
+					if err != nil {
+						t.Fatal(err)
+					}
+					pages = append(pages, ids(got))
+					if next == "" {
+						break
+					}
+					if len(pages) > len(tt.pages) {
+						t.Fatalf("more than %d pages: %v", len(tt.pages), pages)
+					}
+					q.Cursor = next
+				}
+				if !reflect.DeepEqual(pages, tt.pages) {
+					t.Errorf("pages %v, want %v", pages, tt.pages)
+				}
+			})
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+	for name, db := range databases(t) {
+		t.Run(name, func(t *testing.T) {
+			seed(t, db,
+				testItem{ID: "e1", TS: 1, Kind: "view"},
+				testItem{ID: "e2", TS: 1, Kind: "cart"},
+				testItem{ID: "e3", TS: 1, Kind: "view"},
+				testItem{ID: "e4", TS: 1, Kind: "view"},
+				testItem{ID: "e5", TS: 1, Kind: "cart"},
+			)
+
+			var views []testItem
+			if _, err := db.Scan(ctx, "items", Scan{Filter: map[string]interface{}{"kind": "view"}}, &views); err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(views); !reflect.DeepEqual(got, []string{"e1", "e3", "e4"}) {
+				t.Errorf("filtered scan got %v", got)
+			}
+
+			seen := map[string]bool{}
+			s := Scan{Limit: 2}
+			for pages := 0; ; pages++ {
+				if pages > 3 {
+					t.Fatalf("scan did not end after %d pages", pages)
+				}
+				var got []testItem
+				next, err := db.Scan(ctx, "items", s, &got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) > 2 {
+					t.Fatalf("page of %d items with limit 2", len(got))
+				}
+				for _, it := range got {
+					if seen[it.ID] {
+						t.Fatalf("%s read twice", it.ID)
+					}
+					seen[it.ID] = true
+				}
+				if next == "" {
+					break
+				}
+				s.Cursor = next
+			}
+			if len(seen) != 5 {
+				t.Errorf("paged scan read %d items, want 5", len(seen))
+			}
+
+			if _, err := db.Scan(ctx, "items", Scan{Cursor: "not a cursor"}, &views); err == nil {
+				t.Error("invalid cursor accepted")
+			}
+		})
+	}
+}
@@ -2,16 +2,61 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 
+	"github.com/Babatunde13/event-pipeline/internal/tracing"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	batchWriteLimit  = 25 // BatchWriteItem accepts at most 25 put requests
+	batchMaxAttempts = 6
+	batchBaseBackoff = 50 * time.Millisecond
+	batchMaxBackoff  = 2 * time.Second
+)
+
+// ErrUnprocessed is reported for batch items DynamoDB still had not written
+// once the retries were exhausted.
+var ErrUnprocessed = errors.New("item left unprocessed after retries")
+
 var ctx = context.Background()
 
+var tracer = tracing.Tracer("github.com/Babatunde13/event-pipeline/internal/database")
+
+// throttleCodes are the DynamoDB error codes that mean the request was
+// rejected for exceeding capacity rather than for being wrong.
+var throttleCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"ThrottlingException":                    true,
+	"RequestLimitExceeded":                   true,
+}
+
+// IsThrottled reports whether err means the table could not keep up,
+// including batch items left unprocessed after every retry.
+func IsThrottled(err error) bool {
+	if errors.Is(err, ErrUnprocessed) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && throttleCodes[apiErr.ErrorCode()]
+}
+
 type Client struct {
 	client *dynamodb.Client
+	// keySchemas caches the key schema of each table used with Create or
+	// SaveBatch, looked up once through DescribeTable.
+	keySchemas sync.Map
 }
 
 func NewDynamo(cfg *aws.Config) *Client {
@@ -22,7 +67,10 @@ func NewDynamo(cfg *aws.Config) *Client {
 	}
 }
 
-func (c *Client) Save(ctx context.Context, tableName string, data interface{}) error {
+func (c *Client) Save(ctx context.Context, tableName string, data interface{}) (err error) {
+	ctx, span := startSpan(ctx, "PutItem", tableName)
+	defer func() { tracing.End(span, err) }()
+
 	item, err := attributevalue.MarshalMap(data)
 	if err != nil {
 		return err
@@ -38,3 +86,330 @@ func (c *Client) Save(ctx context.Context, tableName string, data interface{}) e
 
 	return nil
 }
+
+func (c *Client) Create(ctx context.Context, tableName string, data interface{}) (err error) {
+	ctx, span := startSpan(ctx, "PutItem", tableName)
+	defer func() {
+		if err == ErrAlreadyExists {
+			// A duplicate is an expected outcome, not a failed write.
+			span.SetAttributes(attribute.Bool("db.duplicate", true))
+			span.End()
+			return
+		}
+		tracing.End(span, err)
+	}()
+
+	item, err := attributevalue.MarshalMap(data)
+	if err != nil {
+		return err
+	}
+	schema, err := c.keySchema(ctx, tableName)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(tableName),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+		ExpressionAttributeNames: map[string]string{"#pk": schema.PartitionKey},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+func (c *Client) keySchema(ctx context.Context, tableName string) (KeySchema, error) {
+	if schema, ok := c.keySchemas.Load(tableName); ok {
+		return schema.(KeySchema), nil
+	}
+	resp, err := c.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return KeySchema{}, err
+	}
+	var schema KeySchema
+	for _, k := range resp.Table.KeySchema {
+		switch k.KeyType {
+		case types.KeyTypeHash:
+			schema.PartitionKey = aws.ToString(k.AttributeName)
+		case types.KeyTypeRange:
+			schema.SortKey = aws.ToString(k.AttributeName)
+		}
+	}
+	if schema.PartitionKey == "" {
+		return KeySchema{}, fmt.Errorf("table %s has no partition key", tableName)
+	}
+	c.keySchemas.Store(tableName, schema)
+	return schema, nil
+}
+
+func (c *Client) SaveBatch(ctx context.Context, tableName string, data []interface{}) []error {
+	ctx, span := startSpan(ctx, "BatchWriteItem", tableName)
+	span.SetAttributes(attribute.Int("db.batch.size", len(data)))
+	defer span.End()
+
+	errs := make([]error, len(data))
+	schema, err := c.keySchema(ctx, tableName)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return errs
+	}
+
+	// BatchWriteItem rejects a whole request that puts the same key twice,
+	// so only the last item with each key is sent, as if the items were
+	// written one after the other, and the others share its result.
+	items := make([]map[string]types.AttributeValue, len(data))
+	keys := make([]string, len(data))
+	last := map[string]int{}
+	for i, d := range data {
+		item, err := attributevalue.MarshalMap(d)
+		if err == nil {
+			keys[i], err = itemKey(item, schema)
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		items[i] = item
+		last[keys[i]] = i
+	}
+	pending := make([]int, 0, len(last))
+	for i := range data {
+		if items[i] != nil && last[keys[i]] == i {
+			pending = append(pending, i)
+		}
+	}
+
+	for start := 0; start < len(pending); start += batchWriteLimit {
+		end := min(start+batchWriteLimit, len(pending))
+		c.writeChunk(ctx, tableName, schema, items, keys, pending[start:end], errs)
+	}
+	for i := range data {
+		if items[i] != nil && last[keys[i]] != i {
+			errs[i] = errs[last[keys[i]]]
+		}
+	}
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		span.SetAttributes(attribute.Int("db.batch.failed", failed))
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d items failed", failed, len(data)))
+	}
+	return errs
+}
+
+// startSpan starts a client span for a DynamoDB operation on table.
+func startSpan(ctx context.Context, operation, table string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "dynamodb."+operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "dynamodb"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("aws.dynamodb.table_names", table),
+		))
+}
+
+func (c *Client) Query(ctx context.Context, tableName string, q Query, out interface{}) (_ string, err error) {
+	ctx, span := startSpan(ctx, "Query", tableName)
+	defer func() { tracing.End(span, err) }()
+	if q.Index != "" {
+		span.SetAttributes(attribute.String("aws.dynamodb.index_name", q.Index))
+	}
+
+	startKey, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return "", err
+	}
+	names := map[string]string{"#pk": q.PartitionKey}
+	values, err := attributevalue.MarshalMap(map[string]interface{}{":pk": q.PartitionValue})
+	if err != nil {
+		return "", err
+	}
+
+	condition := "#pk = :pk"
+	if q.SortKey != "" && (q.SortFrom != nil || q.SortTo != nil) {
+		names["#sk"] = q.SortKey
+		if q.SortFrom != nil {
+			if values[":from"], err = attributevalue.Marshal(q.SortFrom); err != nil {
+				return "", err
+			}
+		}
+		if q.SortTo != nil {
+			if values[":to"], err = attributevalue.Marshal(q.SortTo); err != nil {
+				return "", err
+			}
+		}
+		switch {
+		case q.SortFrom != nil && q.SortTo != nil:
+			condition += " AND #sk BETWEEN :from AND :to"
+		case q.SortFrom != nil:
+			condition += " AND #sk >= :from"
+		default:
+			condition += " AND #sk <= :to"
+		}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ExclusiveStartKey:         startKey,
+	}
+	if q.Index != "" {
+		input.IndexName = aws.String(q.Index)
+	}
+	if q.Limit > 0 {
+		input.Limit = aws.Int32(q.Limit)
+	}
+
+	resp, err := c.client.Query(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	span.SetAttributes(attribute.Int("aws.dynamodb.count", int(resp.Count)))
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, out); err != nil {
+		return "", err
+	}
+	return encodeCursor(resp.LastEvaluatedKey)
+}
+
+func (c *Client) Scan(ctx context.Context, tableName string, s Scan, out interface{}) (_ string, err error) {
+	ctx, span := startSpan(ctx, "Scan", tableName)
+	defer func() { tracing.End(span, err) }()
+
+	startKey, err := decodeCursor(s.Cursor)
+	if err != nil {
+		return "", err
+	}
+	input := &dynamodb.ScanInput{
+		TableName:         aws.String(tableName),
+		ExclusiveStartKey: startKey,
+	}
+	if s.Limit > 0 {
+		input.Limit = aws.Int32(s.Limit)
+	}
+	if len(s.Filter) > 0 {
+		names := map[string]string{}
+		values := map[string]types.AttributeValue{}
+		filter := ""
+		i := 0
+		for attr, value := range s.Filter {
+			name, placeholder := fmt.Sprintf("#f%d", i), fmt.Sprintf(":f%d", i)
+			av, err := attributevalue.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+			names[name] = attr
+			values[placeholder] = av
+			if filter != "" {
+				filter += " AND "
+			}
+			filter += name + " = " + placeholder
+			i++
+		}
+		input.FilterExpression = aws.String(filter)
+		input.ExpressionAttributeNames = names
+		input.ExpressionAttributeValues = values
+	}
+
+	resp, err := c.client.Scan(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	span.SetAttributes(attribute.Int("aws.dynamodb.count", int(resp.Count)))
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, out); err != nil {
+		return "", err
+	}
+	return encodeCursor(resp.LastEvaluatedKey)
+}
+
+// writeChunk writes the items at the given indexes with one BatchWriteItem
+// call, resending whatever DynamoDB reports as unprocessed until it is
+// accepted or batchMaxAttempts is reached. Failures are recorded in errs.
+func (c *Client) writeChunk(ctx context.Context, tableName string, schema KeySchema, items []map[string]types.AttributeValue, keys []string, chunk []int, errs []error) {
+	for attempt := 0; ; attempt++ {
+		requests := make([]types.WriteRequest, len(chunk))
+		for i, idx := range chunk {
+			requests[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: items[idx]}}
+		}
+		out, err := c.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{tableName: requests},
+		})
+		if err != nil {
+			setErr(errs, chunk, err)
+			return
+		}
+
+		chunk = unprocessed(schema, keys, chunk, out.UnprocessedItems[tableName])
+		if len(chunk) == 0 {
+			return
+		}
+		if attempt+1 >= batchMaxAttempts {
+			setErr(errs, chunk, ErrUnprocessed)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			setErr(errs, chunk, ctx.Err())
+			return
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+// unprocessed maps the write requests DynamoDB handed back onto the indexes
+// of the items they came from, by key, which is unique within a chunk.
+func unprocessed(schema KeySchema, keys []string, chunk []int, left []types.WriteRequest) []int {
+	if len(left) == 0 {
+		return nil
+	}
+	byKey := make(map[string]int, len(chunk))
+	for _, idx := range chunk {
+		byKey[keys[idx]] = idx
+	}
+	retry := make([]int, 0, len(left))
+	for _, req := range left {
+		if req.PutRequest == nil {
+			continue
+		}
+		key, err := itemKey(req.PutRequest.Item, schema)
+		if err != nil {
+			continue
+		}
+		if idx, ok := byKey[key]; ok {
+			retry = append(retry, idx)
+			delete(byKey, key)
+		}
+	}
+	return retry
+}
+
+// backoff returns an exponentially growing delay with jitter, so that
+// concurrent consumers hitting the same throttled table spread out.
+func backoff(attempt int) time.Duration {
+	d := batchBaseBackoff << attempt
+	if d > batchMaxBackoff || d <= 0 {
+		d = batchMaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func setErr(errs []error, indexes []int, err error) {
+	for _, idx := range indexes {
+		errs[idx] = err
+	}
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+)
+
+// Sink is one destination of a FanOut and the policy it is written with.
+type Sink struct {
+	Name     string
+	DB       Writer
+	Required bool          // a failure fails the write; otherwise it is only reported
+	Order    int           // sinks are written in ascending Order, equal orders concurrently
+	Timeout  time.Duration // per write; zero leaves only the caller's deadline
+}
+
+// SinkError is a write failure of one sink.
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("sink %s: %v", e.Sink, e.Err)
+}
+
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// FanOut is a Database that writes every item to several sinks. Sinks are
+// written group by group in ascending Order. Only failures of required sinks
+// are returned, joined together; once a required sink fails, the item is not
+// written to later groups. Failures of best-effort sinks go to
+// OnOptionalError. Reads go to the first required sink in Order that
+// supports them, which is taken to be the primary store, since a
+// best-effort sink may have missed writes.
+type FanOut struct {
+	sinks  []Sink // in Order
+	groups [][]Sink
+	// OnOptionalError is called for every failed write to a best-effort
+	// sink. It logs by default.
+	OnOptionalError func(sink string, err error)
+}
+
+func NewFanOut(sinks ...Sink) *FanOut {
+	ordered := append([]Sink(nil), sinks...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Order < ordered[j].Order })
+
+	var groups [][]Sink
+	for i, s := range ordered {
+		if i == 0 || s.Order != ordered[i-1].Order {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], s)
+	}
+	return &FanOut{
+		sinks:  ordered,
+		groups: groups,
+		OnOptionalError: func(sink string, err error) {
+			logging.Warn(context.Background(), "optional sink failed", "sink", sink, logging.Err(err))
+		},
+	}
+}
+
+func (f *FanOut) Save(ctx context.Context, tableName string, item interface{}) error {
+	return f.write(ctx, func(ctx context.Context, db Writer) error {
+		return db.Save(ctx, tableName, item)
+	})
+}
+
+func (f *FanOut) Create(ctx context.Context, tableName string, item interface{}) error {
+	return f.write(ctx, func(ctx context.Context, db Writer) error {
+		return db.Create(ctx, tableName, item)
+	})
+}
+
+func (f *FanOut) write(ctx context.Context, fn func(ctx context.Context, db Writer) error) error {
+	for _, group := range f.groups {
+		errs := make([]error, len(group))
+		var wg sync.WaitGroup
+		for i, s := range group {
+			wg.Add(1)
+			go func(i int, s Sink) {
+				defer wg.Done()
+				sctx, cancel := withTimeout(ctx, s.Timeout)
+				defer cancel()
+				errs[i] = fn(sctx, s.DB)
+			}(i, s)
+		}
+		wg.Wait()
+
+		var failed []error
+		for i, s := range group {
+			if errs[i] == nil {
+				continue
+			}
+			if s.Required {
+				failed = append(failed, &SinkError{Sink: s.Name, Err: errs[i]})
+			} else {
+				f.OnOptionalError(s.Name, errs[i])
+			}
+		}
+		if len(failed) > 0 {
+			return errors.Join(failed...)
+		}
+	}
+	return nil
+}
+
+// SaveBatch writes the batch to each group in turn, leaving out of later
+// groups the items a required sink failed to store.
+func (f *FanOut) SaveBatch(ctx context.Context, tableName string, items []interface{}) []error {
+	failed := make([][]error, len(items))
+	pending := make([]int, len(items))
+	for i := range items {
+		pending[i] = i
+	}
+
+	for _, group := range f.groups {
+		if len(pending) == 0 {
+			break
+		}
+		batch := make([]interface{}, len(pending))
+		for i, idx := range pending {
+			batch[i] = items[idx]
+		}
+
+		results := make([][]error, len(group))
+		var wg sync.WaitGroup
+		for i, s := range group {
+			wg.Add(1)
+			go func(i int, s Sink) {
+				defer wg.Done()
+				sctx, cancel := withTimeout(ctx, s.Timeout)
+				defer cancel()
+				results[i] = s.DB.SaveBatch(sctx, tableName, batch)
+			}(i, s)
+		}
+		wg.Wait()
+
+		next := pending[:0:0]
+		for j, idx := range pending {
+			ok := true
+			for i, s := range group {
+				err := results[i][j]
+				if err == nil {
+					continue
+				}
+				if s.Required {
+					failed[idx] = append(failed[idx], &SinkError{Sink: s.Name, Err: err})
+					ok = false
+				} else {
+					f.OnOptionalError(s.Name, err)
+				}
+			}
+			if ok {
+				next = append(next, idx)
+			}
+		}
+		pending = next
+	}
+
+	errs := make([]error, len(items))
+	for i, e := range failed {
+		if len(e) > 0 {
+			errs[i] = errors.Join(e...)
+		}
+	}
+	return errs
+}
+
+func (f *FanOut) Query(ctx context.Context, tableName string, q Query, out interface{}) (string, error) {
+	r, err := f.reader()
+	if err != nil {
+		return "", err
+	}
+	return r.Query(ctx, tableName, q, out)
+}
+
+func (f *FanOut) Scan(ctx context.Context, tableName string, s Scan, out interface{}) (string, error) {
+	r, err := f.reader()
+	if err != nil {
+		return "", err
+	}
+	return r.Scan(ctx, tableName, s, out)
+}
+
+// reader is the first required sink that supports reads, or failing that
+// the first best-effort one.
+func (f *FanOut) reader() (Reader, error) {
+	var fallback Reader
+	for _, s := range f.sinks {
+		r, ok := s.DB.(Reader)
+		switch {
+		case ok && s.Required:
+			return r, nil
+		case ok && fallback == nil:
+			fallback = r
+		}
+	}
+	if fallback == nil {
+		return nil, errors.New("no sink supports reads")
+	}
+	return fallback, nil
+}
+
+// CreateTable creates the table on every sink that manages its own tables.
+func (f *FanOut) CreateTable(ctx context.Context, tableName string, key KeySchema) error {
+	for _, s := range f.sinks {
+		if creator, ok := s.DB.(TableCreator); ok {
+			if err := creator.CreateTable(ctx, tableName, key); err != nil {
+				return &SinkError{Sink: s.Name, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// Flush flushes every sink that buffers writes. Like writes, only failures
+// of required sinks are returned.
+func (f *FanOut) Flush(ctx context.Context) error {
+	var errs []error
+	for _, s := range f.sinks {
+		flusher, ok := s.DB.(Flusher)
+		if !ok {
+			continue
+		}
+		if err := flusher.Flush(ctx); err != nil {
+			if s.Required {
+				errs = append(errs, &SinkError{Sink: s.Name, Err: err})
+			} else {
+				f.OnOptionalError(s.Name, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every sink that holds connections or buffers.
+func (f *FanOut) Close() error {
+	var errs []error
+	for _, s := range f.sinks {
+		if closer, ok := s.DB.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, &SinkError{Sink: s.Name, Err: err})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
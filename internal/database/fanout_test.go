@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFanOutReader(t *testing.T) {
+	ctx := context.Background()
+	primary, cache, archive := NewMemory(), NewMemory(), NewMemory()
+	for _, db := range []*Memory{primary, cache, archive} {
+		db.CreateTable(ctx, "items", testSchema)
+	}
+	primary.Save(ctx, "items", testItem{ID: "primary", TS: 1})
+	cache.Save(ctx, "items", testItem{ID: "cache", TS: 1})
+	archive.Save(ctx, "items", testItem{ID: "archive", TS: 1})
+
+	tests := []struct {
+		name  string
+		sinks []Sink
+		want  string
+	}{
+		{
+			name: "required sink in a later order",
+			sinks: []Sink{
+				{Name: "archive", DB: archive, Order: 2},
+				{Name: "cache", DB: cache, Order: 0},
+				{Name: "primary", DB: primary, Required: true, Order: 1},
+			},
+			want: "primary",
+		},
+		{
+			name: "first required in order",
+			sinks: []Sink{
+				{Name: "primary", DB: primary, Required: true, Order: 1},
+				{Name: "archive", DB: archive, Required: true, Order: 0},
+			},
+			want: "archive",
+		},
+		{
+			name: "only best-effort sinks",
+			sinks: []Sink{
+				{Name: "archive", DB: archive, Order: 1},
+				{Name: "cache", DB: cache, Order: 0},
+			},
+			want: "cache",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []testItem
+			if _, err := NewFanOut(tt.sinks...).Scan(ctx, "items", Scan{}, &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0].ID != tt.want {
+				t.Errorf("read %v, want the %s sink", ids(got), tt.want)
+			}
+		})
+	}
+}
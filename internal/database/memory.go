@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Memory is an in-process Database for unit tests. It follows the DynamoDB
+// client's rules: tables must be created first, items are marshalled with
+// the same dynamodbav tags and keyed by the table's primary key, Create is
+// conditional on the key, queries come back in sort key order, and Limit
+// counts the items a scan examines rather than the ones it returns.
+type Memory struct {
+	mu     sync.RWMutex
+	tables map[string]*memTable
+}
+
+type memTable struct {
+	schema KeySchema
+	items  map[string]map[string]types.AttributeValue
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		tables: map[string]*memTable{},
+	}
+}
+
+func (m *Memory) CreateTable(ctx context.Context, tableName string, key KeySchema) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tables[tableName]; !ok {
+		m.tables[tableName] = &memTable{
+			schema: key,
+			items:  map[string]map[string]types.AttributeValue{},
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Save(ctx context.Context, tableName string, data interface{}) error {
+	return m.put(tableName, data, false)
+}
+
+func (m *Memory) Create(ctx context.Context, tableName string, data interface{}) error {
+	return m.put(tableName, data, true)
+}
+
+func (m *Memory) SaveBatch(ctx context.Context, tableName string, data []interface{}) []error {
+	errs := make([]error, len(data))
+	for i, d := range data {
+		errs[i] = m.put(tableName, d, false)
+	}
+	return errs
+}
+
+func (m *Memory) put(tableName string, data interface{}, onlyNew bool) error {
+	item, err := attributevalue.MarshalMap(data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.table(tableName)
+	if err != nil {
+		return err
+	}
+	key, err := itemKey(item, t.schema)
+	if err != nil {
+		return err
+	}
+	if _, exists := t.items[key]; exists && onlyNew {
+		return ErrAlreadyExists
+	}
+	t.items[key] = item
+	return nil
+}
+
+func (m *Memory) Query(ctx context.Context, tableName string, q Query, out interface{}) (string, error) {
+	pv, err := attributevalue.Marshal(q.PartitionValue)
+	if err != nil {
+		return "", err
+	}
+	var from, to types.AttributeValue
+	if q.SortFrom != nil {
+		if from, err = attributevalue.Marshal(q.SortFrom); err != nil {
+			return "", err
+		}
+	}
+	if q.SortTo != nil {
+		if to, err = attributevalue.Marshal(q.SortTo); err != nil {
+			return "", err
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, err := m.table(tableName)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []map[string]types.AttributeValue
+	for _, item := range t.items {
+		if !equalValues(item[q.PartitionKey], pv) {
+			continue
+		}
+		if q.SortKey != "" {
+			sv, ok := item[q.SortKey]
+			// Secondary indexes are sparse: items without the index's sort
+			// key are not in the index at all.
+			if !ok && (q.Index != "" || from != nil || to != nil) {
+				continue
+			}
+			if from != nil {
+				if c, ok := compareValues(sv, from); !ok || c < 0 {
+					continue
+				}
+			}
+			if to != nil {
+				if c, ok := compareValues(sv, to); !ok || c > 0 {
+					continue
+				}
+			}
+		}
+		matches = append(matches, item)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if q.SortKey != "" {
+			if c, ok := compareValues(matches[i][q.SortKey], matches[j][q.SortKey]); ok && c != 0 {
+				return c < 0
+			}
+		}
+		ki, _ := itemKey(matches[i], t.schema)
+		kj, _ := itemKey(matches[j], t.schema)
+		return ki < kj
+	})
+
+	page, next, err := window(matches, t.schema, q.Limit, q.Cursor)
+	if err != nil {
+		return "", err
+	}
+	return next, attributevalue.UnmarshalListOfMaps(page, out)
+}
+
+func (m *Memory) Scan(ctx context.Context, tableName string, s Scan, out interface{}) (string, error) {
+	filter, err := attributevalue.MarshalMap(s.Filter)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, err := m.table(tableName)
+	if err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(t.items))
+	for k := range t.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	all := make([]map[string]types.AttributeValue, len(keys))
+	for i, k := range keys {
+		all[i] = t.items[k]
+	}
+
+	page, next, err := window(all, t.schema, s.Limit, s.Cursor)
+	if err != nil {
+		return "", err
+	}
+	kept := page[:0:0]
+	for _, item := range page {
+		if matchesFilter(item, filter) {
+			kept = append(kept, item)
+		}
+	}
+	return next, attributevalue.UnmarshalListOfMaps(kept, out)
+}
+
+func (m *Memory) table(name string) (*memTable, error) {
+	t, ok := m.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+	return t, nil
+}
+
+// window returns the page of ordered items that follows cursor, and the
+// cursor for the page after it. Like DynamoDB, a full page always carries a
+// cursor even if nothing is left to read.
+func window(items []map[string]types.AttributeValue, schema KeySchema, limit int32, cursor string) ([]map[string]types.AttributeValue, string, error) {
+	if cursor != "" {
+		last, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		lastKey, err := itemKey(last, schema)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor: %w", err)
+		}
+		start := len(items)
+		for i, item := range items {
+			if k, _ := itemKey(item, schema); k == lastKey {
+				start = i + 1
+				break
+			}
+		}
+		items = items[start:]
+	}
+
+	if limit <= 0 || len(items) < int(limit) {
+		return items, "", nil
+	}
+	items = items[:limit]
+	last := items[len(items)-1]
+	key := map[string]types.AttributeValue{schema.PartitionKey: last[schema.PartitionKey]}
+	if schema.SortKey != "" {
+		key[schema.SortKey] = last[schema.SortKey]
+	}
+	next, err := encodeCursor(key)
+	return items, next, err
+}
+
+func matchesFilter(item, filter map[string]types.AttributeValue) bool {
+	for attr, want := range filter {
+		if !equalValues(item[attr], want) {
+			return false
+		}
+	}
+	return true
+}
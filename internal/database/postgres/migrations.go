@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migrations are applied in order and recorded in schema_migrations. Append
+// new steps; never edit one that has shipped.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS events (
+		event_id    TEXT PRIMARY KEY,
+		event_time  TIMESTAMPTZ NOT NULL,
+		event_type  TEXT NOT NULL,
+		user_id     TEXT NOT NULL,
+		source      TEXT NOT NULL DEFAULT '',
+		metadata    JSONB NOT NULL DEFAULT '{}'::jsonb,
+		inserted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS events_user_time_idx ON events (user_id, event_time)`,
+	`CREATE INDEX IF NOT EXISTS events_type_idx ON events (event_type)`,
+	`ALTER TABLE events ` + addTimingColumns,
+}
+
+// addTimingColumns adds the pipeline timestamps of an event, which are null
+// for events written before they were stored.
+const addTimingColumns = `ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS consumed_at TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS stored_at TIMESTAMPTZ`
+
+// migrationLock is the advisory lock key that keeps concurrently starting
+// consumers from migrating at the same time.
+const migrationLock = 0x6576656e7473 // "events"
+
+func migrate(ctx context.Context, conn *pgx.Conn) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	var applied int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&applied); err != nil {
+		return err
+	}
+	for i := applied; i < len(migrations); i++ {
+		if _, err := tx.Exec(ctx, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
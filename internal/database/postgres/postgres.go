@@ -0,0 +1,418 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/database"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// baseTable is the table created by the migrations. Other tables handed to
+// CreateTable are created with the same layout.
+const baseTable = "events"
+
+var columns = []string{"event_id", "event_time", "event_type", "user_id", "source", "metadata", "published_at", "consumed_at", "stored_at"}
+
+// attributeColumns maps the event attributes used in queries and scans to
+// the columns that hold them.
+var attributeColumns = map[string]string{
+	"event_id":   "event_id",
+	"timestamp":  "event_time",
+	"event_type": "event_type",
+	"user_id":    "user_id",
+	"source":     "source",
+}
+
+// Client stores events in a typed Postgres table so they can be analysed
+// in SQL. Writes are upserts on event_id, so redelivered events are stored
+// once. Only event.Event values can be written.
+type Client struct {
+	pool *pgxpool.Pool
+}
+
+// New connects to dsn and brings the schema up to date before returning.
+func New(ctx context.Context, dsn string) (*Client, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	err = migrate(ctx, conn.Conn())
+	conn.Release()
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return &Client{pool: pool}, nil
+}
+
+func (c *Client) Close() error {
+	c.pool.Close()
+	return nil
+}
+
+func (c *Client) CreateTable(ctx context.Context, tableName string, key database.KeySchema) error {
+	if tableName == baseTable {
+		return nil
+	}
+	_, err := c.pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (LIKE %s INCLUDING ALL)`,
+		ident(tableName), ident(baseTable)))
+	if err != nil {
+		return err
+	}
+	// Tables copied from the layout before a migration miss its columns.
+	_, err = c.pool.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s %s`, ident(tableName), addTimingColumns))
+	return err
+}
+
+func (c *Client) Save(ctx context.Context, tableName string, item interface{}) error {
+	e, err := toEvent(item)
+	if err != nil {
+		return err
+	}
+	_, err = c.pool.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)
+		ON CONFLICT (event_id) DO UPDATE SET %s`,
+		ident(tableName), strings.Join(columns, ", "), placeholders(), updateSet()), row(e)...)
+	return err
+}
+
+func (c *Client) Create(ctx context.Context, tableName string, item interface{}) error {
+	e, err := toEvent(item)
+	if err != nil {
+		return err
+	}
+	tag, err := c.pool.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)
+		ON CONFLICT (event_id) DO NOTHING`,
+		ident(tableName), strings.Join(columns, ", "), placeholders()), row(e)...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrAlreadyExists
+	}
+	return nil
+}
+
+// SaveBatch copies the events into a staging table and upserts them from
+// there in one statement, since COPY alone cannot resolve conflicts.
+func (c *Client) SaveBatch(ctx context.Context, tableName string, items []interface{}) []error {
+	errs := make([]error, len(items))
+	rows := make([][]interface{}, 0, len(items))
+	copied := make([]int, 0, len(items))
+	for i, item := range items {
+		e, err := toEvent(item)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		rows = append(rows, row(e))
+		copied = append(copied, i)
+	}
+	if len(rows) == 0 {
+		return errs
+	}
+
+	const stage = "events_stage"
+	err := pgx.BeginFunc(ctx, c.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, fmt.Sprintf(`CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP`,
+			stage, ident(tableName)))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{stage}, columns, pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+		// A batch can carry the same event twice after a redelivery, and
+		// ON CONFLICT refuses to touch one row twice in a statement.
+		_, err = tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %[1]s (%[2]s)
+			SELECT DISTINCT ON (event_id) %[2]s FROM %[3]s ORDER BY event_id, event_time DESC
+			ON CONFLICT (event_id) DO UPDATE SET %[4]s`,
+			ident(tableName), strings.Join(columns, ", "), stage, updateSet()))
+		return err
+	})
+	if err != nil {
+		for _, i := range copied {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func (c *Client) Query(ctx context.Context, tableName string, q database.Query, out interface{}) (string, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	pkCol, pv, err := param(q.PartitionKey, q.PartitionValue)
+	if err != nil {
+		return "", err
+	}
+	where := []string{pkCol + " = " + arg(pv)}
+	order := []string{"event_id"}
+
+	sortCol := ""
+	if q.SortKey != "" {
+		if sortCol, err = column(q.SortKey); err != nil {
+			return "", err
+		}
+		order = []string{sortCol, "event_id"}
+		for _, bound := range []struct {
+			op    string
+			value interface{}
+		}{{">=", q.SortFrom}, {"<=", q.SortTo}} {
+			if bound.value == nil {
+				continue
+			}
+			_, v, err := param(q.SortKey, bound.value)
+			if err != nil {
+				return "", err
+			}
+			where = append(where, sortCol+" "+bound.op+" "+arg(v))
+		}
+	}
+
+	if q.Cursor != "" {
+		cur, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return "", err
+		}
+		if sortCol != "" {
+			_, sv, err := param(q.SortKey, cur.Sort)
+			if err != nil {
+				return "", err
+			}
+			where = append(where, fmt.Sprintf("(%s, event_id) > (%s, %s)", sortCol, arg(sv), arg(cur.EventID)))
+		} else {
+			where = append(where, "event_id > "+arg(cur.EventID))
+		}
+	}
+
+	return c.read(ctx, tableName, where, order, args, q.SortKey, q.Limit, out)
+}
+
+func (c *Client) Scan(ctx context.Context, tableName string, s database.Scan, out interface{}) (string, error) {
+	var args []interface{}
+	var where []string
+	for attr, value := range s.Filter {
+		col, v, err := param(attr, value)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, v)
+		where = append(where, col+" = $"+strconv.Itoa(len(args)))
+	}
+	if s.Cursor != "" {
+		cur, err := decodeCursor(s.Cursor)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, cur.EventID)
+		where = append(where, "event_id > $"+strconv.Itoa(len(args)))
+	}
+	return c.read(ctx, tableName, where, []string{"event_id"}, args, "", s.Limit, out)
+}
+
+func (c *Client) read(ctx context.Context, tableName string, where, order []string, args []interface{}, sortKey string, limit int32, out interface{}) (string, error) {
+	stmt := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), ident(tableName))
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY " + strings.Join(order, ", ")
+	if limit > 0 {
+		stmt += " LIMIT " + strconv.Itoa(int(limit))
+	}
+
+	rows, err := c.pool.Query(ctx, stmt, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var found []event.Event
+	for rows.Next() {
+		var e event.Event
+		var at time.Time
+		var published, consumed, stored *time.Time
+		var source string
+		if err := rows.Scan(&e.EventID, &at, &e.EventType, &e.UserID, &source, &e.Metadata, &published, &consumed, &stored); err != nil {
+			return "", err
+		}
+		e.Timestamp = at.UnixMilli()
+		e.Source = event.EventSource(source)
+		e.PublishedAt, e.ConsumedAt, e.StoredAt = fromTime(published), fromTime(consumed), fromTime(stored)
+		found = append(found, e)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// Decode through the same attribute mapping as the other databases, so
+	// callers can read into any type tagged for DynamoDB.
+	items := make([]map[string]types.AttributeValue, len(found))
+	for i := range found {
+		if items[i], err = attributevalue.MarshalMap(found[i]); err != nil {
+			return "", err
+		}
+	}
+	if err := attributevalue.UnmarshalListOfMaps(items, out); err != nil {
+		return "", err
+	}
+
+	if limit <= 0 || len(found) < int(limit) {
+		return "", nil
+	}
+	last := found[len(found)-1]
+	cur := cursor{EventID: last.EventID}
+	switch sortKey {
+	case "":
+	case "timestamp":
+		cur.Sort = last.Timestamp
+	case "event_type":
+		cur.Sort = last.EventType
+	case "user_id":
+		cur.Sort = last.UserID
+	case "source":
+		cur.Sort = last.Source
+	}
+	return encodeCursor(cur)
+}
+
+func toEvent(item interface{}) (*event.Event, error) {
+	switch e := item.(type) {
+	case *event.Event:
+		return e, nil
+	case event.Event:
+		return &e, nil
+	default:
+		return nil, fmt.Errorf("postgres: cannot store %T, only event.Event", item)
+	}
+}
+
+func row(e *event.Event) []interface{} {
+	metadata := e.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return []interface{}{
+		e.EventID,
+		time.UnixMilli(e.Timestamp).UTC(),
+		string(e.EventType),
+		e.UserID,
+		string(e.Source),
+		metadata,
+		toTime(e.PublishedAt),
+		toTime(e.ConsumedAt),
+		toTime(e.StoredAt),
+	}
+}
+
+// toTime converts a pipeline timestamp in milliseconds to a column value,
+// null when it was never set.
+func toTime(ms int64) *time.Time {
+	if ms == 0 {
+		return nil
+	}
+	t := time.UnixMilli(ms).UTC()
+	return &t
+}
+
+func fromTime(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func placeholders() string {
+	p := make([]string, len(columns))
+	for i := range p {
+		p[i] = "$" + strconv.Itoa(i+1)
+	}
+	return strings.Join(p, ", ")
+}
+
+func updateSet() string {
+	set := make([]string, 0, len(columns)-1)
+	for _, col := range columns[1:] {
+		set = append(set, col+" = EXCLUDED."+col)
+	}
+	return strings.Join(set, ", ")
+}
+
+func column(attr string) (string, error) {
+	col, ok := attributeColumns[attr]
+	if !ok {
+		return "", fmt.Errorf("postgres: no column for attribute %q", attr)
+	}
+	return col, nil
+}
+
+// param returns the column for attr and v converted to that column's type.
+func param(attr string, v interface{}) (string, interface{}, error) {
+	col, err := column(attr)
+	if err != nil {
+		return "", nil, err
+	}
+	if col != "event_time" {
+		return col, fmt.Sprint(v), nil
+	}
+	switch ms := v.(type) {
+	case int64:
+		return col, time.UnixMilli(ms).UTC(), nil
+	case int:
+		return col, time.UnixMilli(int64(ms)).UTC(), nil
+	case float64:
+		return col, time.UnixMilli(int64(ms)).UTC(), nil
+	case time.Time:
+		return col, ms, nil
+	default:
+		return "", nil, fmt.Errorf("postgres: unsupported timestamp value %T", v)
+	}
+}
+
+func ident(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// cursor marks the last row of a page: its event ID and, for queries, the
+// value of the column the rows were ordered by.
+type cursor struct {
+	Sort    interface{} `json:"s,omitempty"`
+	EventID string      `json:"id"`
+}
+
+func encodeCursor(c cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/database"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+)
+
+// testClient connects to POSTGRES_DSN, skipping the test when it is unset,
+// and returns a client with a fresh table that is dropped afterwards.
+func testClient(t *testing.T) (*Client, string) {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+	ctx := context.Background()
+	c, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	table := fmt.Sprintf("events_test_%d", time.Now().UnixNano())
+	if err := c.CreateTable(ctx, table, database.KeySchema{PartitionKey: "event_id"}); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	t.Cleanup(func() { c.pool.Exec(context.Background(), `DROP TABLE IF EXISTS `+ident(table)) })
+	return c, table
+}
+
+// timingColumns returns which of the pipeline timestamp columns table has.
+func timingColumns(t *testing.T, c *Client, table string) []string {
+	t.Helper()
+	rows, err := c.pool.Query(context.Background(), `SELECT column_name FROM information_schema.columns
+		WHERE table_name = $1 AND column_name IN ('published_at', 'consumed_at', 'stored_at')
+		ORDER BY column_name`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+func TestMigrate(t *testing.T) {
+	c, table := testClient(t)
+	ctx := context.Background()
+
+	// Migrating again applies nothing twice.
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrate(ctx, conn.Conn())
+	conn.Release()
+	if err != nil {
+		t.Fatalf("migrate again: %v", err)
+	}
+	var version, applied int
+	if err := c.pool.QueryRow(ctx, `SELECT MAX(version), COUNT(*) FROM schema_migrations`).Scan(&version, &applied); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) || applied != len(migrations) {
+		t.Errorf("schema at version %d with %d applied, want %d", version, applied, len(migrations))
+	}
+
+	want := []string{"consumed_at", "published_at", "stored_at"}
+	if got := timingColumns(t, c, baseTable); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s has timing columns %v, want %v", baseTable, got, want)
+	}
+
+	// A table copied before the timing columns existed gets them.
+	if _, err := c.pool.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s DROP COLUMN published_at, DROP COLUMN consumed_at, DROP COLUMN stored_at`, ident(table))); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateTable(ctx, table, database.KeySchema{PartitionKey: "event_id"}); err != nil {
+		t.Fatalf("create table again: %v", err)
+	}
+	if got := timingColumns(t, c, table); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s has timing columns %v after CreateTable, want %v", table, got, want)
+	}
+}
+
+func TestWrites(t *testing.T) {
+	c, table := testClient(t)
+	ctx := context.Background()
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	timed := func(id string, user string, stored int64) event.Event {
+		return event.Event{
+			EventID:     id,
+			Timestamp:   at,
+			EventType:   event.ViewProduct,
+			UserID:      user,
+			Source:      event.SourceKafka,
+			Metadata:    map[string]interface{}{"product_id": "p1"},
+			PublishedAt: at + 1,
+			ConsumedAt:  at + 2,
+			StoredAt:    stored,
+		}
+	}
+
+	// COPY through the staging table, with the same event twice.
+	errs := c.SaveBatch(ctx, table, []interface{}{timed("a", "u1", at+3), timed("b", "u1", at+3), timed("a", "u1", at+3), "not an event"})
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Fatalf("batch item %d: %v", i, err)
+		}
+	}
+	if errs[3] == nil {
+		t.Error("stored a value that is not an event")
+	}
+
+	// Save upserts, Create leaves an existing event alone.
+	if err := c.Save(ctx, table, timed("b", "u2", at+4)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := c.Create(ctx, table, timed("b", "u3", at+5)); !errors.Is(err, database.ErrAlreadyExists) {
+		t.Fatalf("create over an existing event: got %v, want %v", err, database.ErrAlreadyExists)
+	}
+	plain := event.Event{EventID: "c", Timestamp: at, EventType: event.AddToCart, UserID: "u1", Metadata: map[string]interface{}{"product_id": "p2"}}
+	if err := c.Create(ctx, table, plain); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	var got []event.Event
+	if _, err := c.Scan(ctx, table, database.Scan{}, &got); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].EventID < got[j].EventID })
+	want := []event.Event{timed("a", "u1", at+3), timed("b", "u2", at+4), plain}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read back\n%+v\nwant\n%+v", got, want)
+	}
+
+	var byUser []event.Event
+	q := database.Query{PartitionKey: "user_id", PartitionValue: "u1", SortKey: "timestamp", SortFrom: at, SortTo: at}
+	if _, err := c.Query(ctx, table, q, &byUser); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(byUser) != 2 || byUser[0].EventID != "a" || byUser[0].StoredAt != at+3 {
+		t.Errorf("query for u1 returned %+v, want a and c with their timestamps", byUser)
+	}
+}
+
+func TestTimingColumns(t *testing.T) {
+	e := &event.Event{EventID: "a", PublishedAt: 1700000000001, StoredAt: 1700000000003}
+	r := row(e)
+	if len(r) != len(columns) {
+		t.Fatalf("row has %d values for %d columns", len(r), len(columns))
+	}
+	published, consumed, stored := r[6].(*time.Time), r[7].(*time.Time), r[8].(*time.Time)
+	if consumed != nil {
+		t.Errorf("unset consumed_at written as %v, want null", consumed)
+	}
+	if fromTime(published) != e.PublishedAt || fromTime(stored) != e.StoredAt || fromTime(consumed) != 0 {
+		t.Errorf("read back %d, %d and %d, want %d, 0 and %d", fromTime(published), fromTime(consumed), fromTime(stored), e.PublishedAt, e.StoredAt)
+	}
+}
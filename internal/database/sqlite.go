@@ -0,0 +1,335 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	_ "modernc.org/sqlite"
+)
+
+// SQLite is a Database kept in a single SQLite file, for running the
+// consumers end to end without AWS. Every table stores its items as JSON
+// alongside their rendered primary key, and reads filter on the JSON
+// attributes, so the same items, queries and scans work as on DynamoDB.
+type SQLite struct {
+	db      *sql.DB
+	mu      sync.RWMutex
+	schemas map[string]KeySchema
+}
+
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serialises writers anyway; a single connection avoids
+	// SQLITE_BUSY errors between the pool's own connections.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLite{
+		db:      db,
+		schemas: map[string]KeySchema{},
+	}, nil
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLite) CreateTable(ctx context.Context, tableName string, key KeySchema) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		pk   TEXT NOT NULL,
+		sk   TEXT NOT NULL,
+		item TEXT NOT NULL,
+		PRIMARY KEY (pk, sk)
+	)`, quoteIdent(tableName)))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.schemas[tableName] = key
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *SQLite) Save(ctx context.Context, tableName string, data interface{}) error {
+	return s.put(ctx, s.db, tableName, data, false)
+}
+
+func (s *SQLite) Create(ctx context.Context, tableName string, data interface{}) error {
+	return s.put(ctx, s.db, tableName, data, true)
+}
+
+func (s *SQLite) SaveBatch(ctx context.Context, tableName string, data []interface{}) []error {
+	errs := make([]error, len(data))
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, d := range data {
+		errs[i] = s.put(ctx, tx, tableName, d, false)
+	}
+	if err := tx.Commit(); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *SQLite) put(ctx context.Context, db execer, tableName string, data interface{}, onlyNew bool) error {
+	schema, err := s.schema(tableName)
+	if err != nil {
+		return err
+	}
+	item, err := attributevalue.MarshalMap(data)
+	if err != nil {
+		return err
+	}
+	if _, err := itemKey(item, schema); err != nil {
+		return err
+	}
+	doc, err := encodeItem(item)
+	if err != nil {
+		return err
+	}
+	pk, sk := keyColumns(item, schema)
+
+	if !onlyNew {
+		_, err = db.ExecContext(ctx, fmt.Sprintf(
+			`INSERT OR REPLACE INTO %s (pk, sk, item) VALUES (?, ?, ?)`, quoteIdent(tableName)), pk, sk, doc)
+		return err
+	}
+	res, err := db.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (pk, sk, item) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`, quoteIdent(tableName)), pk, sk, doc)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+func (s *SQLite) Query(ctx context.Context, tableName string, q Query, out interface{}) (string, error) {
+	schema, err := s.schema(tableName)
+	if err != nil {
+		return "", err
+	}
+	pv, err := plainValue(q.PartitionValue)
+	if err != nil {
+		return "", err
+	}
+
+	where := []string{"json_extract(item, ?) = ?"}
+	args := []interface{}{jsonPath(q.PartitionKey), pv}
+	order := "pk, sk"
+	sortExpr := ""
+	if q.SortKey != "" {
+		sortExpr = "json_extract(item, " + sqlString(jsonPath(q.SortKey)) + ")"
+		order = sortExpr + ", pk, sk"
+		if q.Index != "" || q.SortFrom != nil || q.SortTo != nil {
+			where = append(where, sortExpr+" IS NOT NULL")
+		}
+		for _, bound := range []struct {
+			op    string
+			value interface{}
+		}{{">=", q.SortFrom}, {"<=", q.SortTo}} {
+			if bound.value == nil {
+				continue
+			}
+			v, err := plainValue(bound.value)
+			if err != nil {
+				return "", err
+			}
+			where = append(where, sortExpr+" "+bound.op+" ?")
+			args = append(args, v)
+		}
+	}
+
+	if q.Cursor != "" {
+		last, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return "", err
+		}
+		pk, sk := keyColumns(last, schema)
+		if sortExpr != "" {
+			sv, err := plainValue(last[q.SortKey])
+			if err != nil {
+				return "", err
+			}
+			where = append(where, "("+sortExpr+", pk, sk) > (?, ?, ?)")
+			args = append(args, sv, pk, sk)
+		} else {
+			where = append(where, "(pk, sk) > (?, ?)")
+			args = append(args, pk, sk)
+		}
+	}
+
+	return s.read(ctx, tableName, schema, q.SortKey, where, args, order, q.Limit, out)
+}
+
+func (s *SQLite) Scan(ctx context.Context, tableName string, sc Scan, out interface{}) (string, error) {
+	schema, err := s.schema(tableName)
+	if err != nil {
+		return "", err
+	}
+
+	var where []string
+	var args []interface{}
+	for attr, value := range sc.Filter {
+		v, err := plainValue(value)
+		if err != nil {
+			return "", err
+		}
+		where = append(where, "json_extract(item, ?) = ?")
+		args = append(args, jsonPath(attr), v)
+	}
+	if sc.Cursor != "" {
+		last, err := decodeCursor(sc.Cursor)
+		if err != nil {
+			return "", err
+		}
+		pk, sk := keyColumns(last, schema)
+		where = append(where, "(pk, sk) > (?, ?)")
+		args = append(args, pk, sk)
+	}
+
+	return s.read(ctx, tableName, schema, "", where, args, "pk, sk", sc.Limit, out)
+}
+
+// read runs a select over tableName and decodes the rows into out. When the
+// page is full it returns a cursor made of the last row's key attributes,
+// plus sortKey when the rows were ordered by it.
+func (s *SQLite) read(ctx context.Context, tableName string, schema KeySchema, sortKey string, where []string, args []interface{}, order string, limit int32, out interface{}) (string, error) {
+	stmt := "SELECT item FROM " + quoteIdent(tableName)
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY " + order
+	if limit > 0 {
+		stmt += " LIMIT " + strconv.Itoa(int(limit))
+	}
+
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var items []map[string]types.AttributeValue
+	for rows.Next() {
+		var doc string
+		if err := rows.Scan(&doc); err != nil {
+			return "", err
+		}
+		item, err := decodeItem(doc)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if err := attributevalue.UnmarshalListOfMaps(items, out); err != nil {
+		return "", err
+	}
+
+	if limit <= 0 || len(items) < int(limit) {
+		return "", nil
+	}
+	last := items[len(items)-1]
+	key := map[string]types.AttributeValue{schema.PartitionKey: last[schema.PartitionKey]}
+	if schema.SortKey != "" {
+		key[schema.SortKey] = last[schema.SortKey]
+	}
+	if sortKey != "" {
+		key[sortKey] = last[sortKey]
+	}
+	return encodeCursor(key)
+}
+
+func (s *SQLite) schema(tableName string) (KeySchema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schema, ok := s.schemas[tableName]
+	if !ok {
+		return KeySchema{}, fmt.Errorf("table %s does not exist", tableName)
+	}
+	return schema, nil
+}
+
+func keyColumns(item map[string]types.AttributeValue, schema KeySchema) (string, string) {
+	pk := keyString(item[schema.PartitionKey])
+	sk := ""
+	if schema.SortKey != "" {
+		sk = keyString(item[schema.SortKey])
+	}
+	return pk, sk
+}
+
+func encodeItem(item map[string]types.AttributeValue) (string, error) {
+	var plain map[string]interface{}
+	if err := attributevalue.UnmarshalMap(item, &plain); err != nil {
+		return "", err
+	}
+	doc, err := json.Marshal(plain)
+	return string(doc), err
+}
+
+func decodeItem(doc string) (map[string]types.AttributeValue, error) {
+	var plain map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &plain); err != nil {
+		return nil, err
+	}
+	return attributevalue.MarshalMap(plain)
+}
+
+// plainValue converts v to the Go value SQLite compares json_extract results
+// against: numbers become float64, strings stay strings.
+func plainValue(v interface{}) (interface{}, error) {
+	av, ok := v.(types.AttributeValue)
+	if !ok {
+		var err error
+		if av, err = attributevalue.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var plain interface{}
+	if err := attributevalue.Unmarshal(av, &plain); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+func jsonPath(attr string) string {
+	return "$." + strconv.Quote(attr)
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -3,6 +3,8 @@ package event
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/Babatunde13/event-pipeline/internal/database"
@@ -34,6 +36,62 @@ type Event struct {
 	UserID    string                 `json:"user_id" dynamodbav:"user_id"`
 	Metadata  map[string]interface{} `json:"metadata" dynamodbav:"metadata"`
 	Source    EventSource            `dynamodbav:"source,omitempty"`
+
+	// Hop timestamps in Unix milliseconds, stamped as the event moves
+	// through the pipeline. Timestamp above is when the producer received it.
+	PublishedAt int64 `json:"published_at,omitempty" dynamodbav:"published_at,omitempty"` // handed to the broker
+	ConsumedAt  int64 `json:"consumed_at,omitempty" dynamodbav:"consumed_at,omitempty"`   // received by the consumer
+	StoredAt    int64 `json:"stored_at,omitempty" dynamodbav:"stored_at,omitempty"`       // written to the sink
+
+	// Storage attributes, filled in by Save according to the configured
+	// layout and TTL.
+	PK        string `json:"-" dynamodbav:"pk,omitempty"`
+	SK        string `json:"-" dynamodbav:"sk,omitempty"`
+	ExpiresAt int64  `json:"-" dynamodbav:"expires_at,omitempty"` // epoch seconds, the table's TTL attribute
+
+	// TraceContext carries the W3C trace context through EventBridge, which
+	// has no message headers. It is not stored.
+	TraceContext map[string]string `json:"trace_context,omitempty" dynamodbav:"-"`
+}
+
+// Valid reports whether t is one of the known event types.
+func (t EventType) Valid() bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelOther is the metric label of events of no known type.
+const LabelOther = "other"
+
+// Label is t as a metric label. Event types come from untrusted input, so
+// anything but the known types is LabelOther.
+func (t EventType) Label() string {
+	if !t.Valid() {
+		return LabelOther
+	}
+	return string(t)
+}
+
+// SetTraceContext attaches the trace context of the publishing span.
+func (e *Event) SetTraceContext(carrier map[string]string) {
+	e.TraceContext = carrier
+}
+
+// Validate checks the fields every stored event needs.
+func (e *Event) Validate() error {
+	switch {
+	case e.EventID == "":
+		return errors.New("event_id is required")
+	case e.UserID == "":
+		return errors.New("user_id is required")
+	case !e.EventType.Valid():
+		return fmt.Errorf("unknown event_type %q", e.EventType)
+	}
+	return nil
 }
 
 func New(eventType EventType, userID string, metadata map[string]interface{}) Event {
@@ -46,12 +104,26 @@ func New(eventType EventType, userID string, metadata map[string]interface{}) Ev
 	}
 }
 
-func (e *Event) Duration() float64 {
+// Duration is the time since the producer received the event.
+func (e *Event) Duration() time.Duration {
 	if e.Timestamp == 0 {
 		return 0
 	}
-	startTime := time.Unix(0, e.Timestamp*int64(time.Millisecond))
-	return float64(time.Since(startTime).Milliseconds())
+	return time.Since(time.UnixMilli(e.Timestamp))
+}
+
+// Between returns the time from one hop timestamp to another, or zero when
+// either was not stamped.
+func Between(from, to int64) time.Duration {
+	if from == 0 || to == 0 {
+		return 0
+	}
+	return time.Duration(to-from) * time.Millisecond
+}
+
+// Now is the current time in the unit of the hop timestamps.
+func Now() int64 {
+	return time.Now().UTC().UnixMilli()
 }
 
 func (e *Event) ToJSON() ([]byte, error) {
@@ -64,11 +136,29 @@ func FromJSON(data []byte) (*Event, error) {
 	return &e, err
 }
 
-func (e *Event) Save(ctx context.Context, dbClient database.Database, source EventSource) error {
-	e.Source = source
-	err := dbClient.Save(ctx, "events", e)
+// Create stores the event unless it was stored before, in which case it
+// returns database.ErrAlreadyExists.
+func (e *Event) Create(ctx context.Context, dbClient database.Writer, source EventSource) error {
+	store.prepare(e, source)
+	return dbClient.Create(ctx, store.table(source), e)
+}
+
+func (e *Event) Save(ctx context.Context, dbClient database.Writer, source EventSource) error {
+	store.prepare(e, source)
+	err := dbClient.Save(ctx, store.table(source), e)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// SaveBatch stores events in one batched write and returns one error per
+// event, in the same order as events.
+func SaveBatch(ctx context.Context, dbClient database.Writer, source EventSource, events []*Event) []error {
+	items := make([]interface{}, len(events))
+	for i, e := range events {
+		store.prepare(e, source)
+		items[i] = e
+	}
+	return dbClient.SaveBatch(ctx, store.table(source), items)
+}
@@ -0,0 +1,269 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/config"
+	"github.com/Babatunde13/event-pipeline/internal/database"
+)
+
+// KeyLayout selects how events are keyed in their table.
+type KeyLayout string
+
+const (
+	// LayoutEvent keys events by event_id and timestamp.
+	LayoutEvent KeyLayout = "event"
+	// LayoutUser keys events by pk = USER#<user_id> and
+	// sk = TS#<timestamp>#<event_id>, so a user's history is read straight
+	// from the table. The event ID keeps two events of one user in the same
+	// millisecond apart.
+	LayoutUser KeyLayout = "user"
+)
+
+const (
+	defaultTable = "events"
+	// userIndex is the global secondary index keyed by user_id and
+	// timestamp, used with LayoutEvent.
+	userIndex = "user_id-timestamp-index"
+	// eventIndex is the global secondary index keyed by event_id, used with
+	// LayoutUser.
+	eventIndex = "event_id-index"
+)
+
+// Storage describes where and how events are stored.
+type Storage struct {
+	Table        string
+	SourceTables map[EventSource]string // overrides Table per source
+	TTL          time.Duration          // zero stores events without expires_at
+	Layout       KeyLayout
+}
+
+var store = Storage{Table: defaultTable, Layout: LayoutEvent}
+
+// Configure applies the table settings from cfg to every later read and
+// write in this package.
+func Configure(cfg *config.Config) error {
+	s := Storage{
+		Table:        defaultTable,
+		SourceTables: map[EventSource]string{},
+		Layout:       LayoutEvent,
+	}
+	if cfg.EventsTable != "" {
+		s.Table = cfg.EventsTable
+	}
+	if cfg.KafkaEventsTable != "" {
+		s.SourceTables[SourceKafka] = cfg.KafkaEventsTable
+	}
+	if cfg.EventBridgeEventsTable != "" {
+		s.SourceTables[SourceEventBridge] = cfg.EventBridgeEventsTable
+	}
+	if cfg.EventsTTL != "" {
+		ttl, err := time.ParseDuration(cfg.EventsTTL)
+		if err != nil {
+			return fmt.Errorf("invalid EVENTS_TTL: %w", err)
+		}
+		s.TTL = ttl
+	}
+	switch layout := KeyLayout(strings.ToLower(cfg.EventsKeyLayout)); layout {
+	case "", LayoutEvent:
+	case LayoutUser:
+		s.Layout = layout
+	default:
+		return fmt.Errorf("invalid EVENTS_KEY_LAYOUT %q", cfg.EventsKeyLayout)
+	}
+	store = s
+	return nil
+}
+
+func (s Storage) table(source EventSource) string {
+	if t, ok := s.SourceTables[source]; ok {
+		return t
+	}
+	return s.Table
+}
+
+// tables lists every table events can be stored in, without duplicates.
+func (s Storage) tables() []string {
+	tables := []string{s.Table}
+	for _, t := range s.SourceTables {
+		seen := false
+		for _, existing := range tables {
+			seen = seen || existing == t
+		}
+		if !seen {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+func (s Storage) keySchema() database.KeySchema {
+	if s.Layout == LayoutUser {
+		return database.KeySchema{PartitionKey: "pk", SortKey: "sk"}
+	}
+	return database.KeySchema{PartitionKey: "event_id", SortKey: "timestamp"}
+}
+
+// prepare fills in the source and the storage attributes of e before it is
+// written.
+func (s Storage) prepare(e *Event, source EventSource) {
+	e.Source = source
+	e.StoredAt = Now()
+	if s.Layout == LayoutUser {
+		e.PK = userPK(e.UserID)
+		e.SK = fmt.Sprintf("%s#%s", timeSK(e.Timestamp), e.EventID)
+	}
+	if s.TTL > 0 {
+		created := time.Now()
+		if e.Timestamp != 0 {
+			created = time.UnixMilli(e.Timestamp)
+		}
+		e.ExpiresAt = created.Add(s.TTL).Unix()
+	}
+}
+
+func userPK(userID string) string {
+	return "USER#" + userID
+}
+
+// timeSK zero-pads the timestamp so that sort keys order chronologically.
+func timeSK(ms int64) string {
+	return fmt.Sprintf("TS#%013d", ms)
+}
+
+// CreateTables creates the event tables on databases that manage their own
+// tables. On DynamoDB the tables are provisioned with Terraform instead.
+func CreateTables(ctx context.Context, dbClient database.TableCreator) error {
+	for _, t := range store.tables() {
+		if err := dbClient.CreateTable(ctx, t, store.keySchema()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Page is one page of events read back from the database. Cursor is empty on
+// the last page.
+type Page struct {
+	Events []Event
+	Cursor string
+}
+
+// Get returns the stored event with the given ID, looking through every
+// configured table, or database.ErrNotFound.
+func Get(ctx context.Context, dbClient database.Reader, eventID string) (*Event, error) {
+	q := database.Query{
+		PartitionKey:   "event_id",
+		PartitionValue: eventID,
+		Limit:          1,
+	}
+	if store.Layout == LayoutUser {
+		q.Index = eventIndex
+	}
+	for _, t := range store.tables() {
+		var found []Event
+		if _, err := dbClient.Query(ctx, t, q, &found); err != nil {
+			return nil, err
+		}
+		if len(found) > 0 {
+			return &found[0], nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+// GetAll returns every stored copy of the event with the given ID across
+// the configured tables, so an event delivered more than once is seen more
+// than once. No copies is not an error.
+func GetAll(ctx context.Context, dbClient database.Reader, eventID string) ([]Event, error) {
+	q := database.Query{
+		PartitionKey:   "event_id",
+		PartitionValue: eventID,
+	}
+	if store.Layout == LayoutUser {
+		q.Index = eventIndex
+	}
+	var all []Event
+	for _, t := range store.tables() {
+		q.Cursor = ""
+		for {
+			var found []Event
+			next, err := dbClient.Query(ctx, t, q, &found)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, found...)
+			if next == "" {
+				break
+			}
+			q.Cursor = next
+		}
+	}
+	return all, nil
+}
+
+// QueryByUser returns the events of a user with a timestamp between from and
+// to, inclusive, from the table events of source are stored in. A zero from
+// or to leaves that end of the range open.
+func QueryByUser(ctx context.Context, dbClient database.Reader, source EventSource, userID string, from, to time.Time, cursor string, limit int32) (*Page, error) {
+	var q database.Query
+	if store.Layout == LayoutUser {
+		q = database.Query{
+			PartitionKey:   "pk",
+			PartitionValue: userPK(userID),
+			SortKey:        "sk",
+			SortFrom:       timeSK(0),
+			// '$' sorts right after the '#' that separates the event ID.
+			SortTo: timeSK(9999999999999) + "$",
+		}
+		if !from.IsZero() {
+			q.SortFrom = timeSK(from.UnixMilli())
+		}
+		if !to.IsZero() {
+			q.SortTo = timeSK(to.UnixMilli()) + "$"
+		}
+	} else {
+		q = database.Query{
+			Index:          userIndex,
+			PartitionKey:   "user_id",
+			PartitionValue: userID,
+			SortKey:        "timestamp",
+		}
+		if !from.IsZero() {
+			q.SortFrom = from.UnixMilli()
+		}
+		if !to.IsZero() {
+			q.SortTo = to.UnixMilli()
+		}
+	}
+	q.Limit = limit
+	q.Cursor = cursor
+
+	page := &Page{}
+	next, err := dbClient.Query(ctx, store.table(source), q, &page.Events)
+	if err != nil {
+		return nil, err
+	}
+	page.Cursor = next
+	return page, nil
+}
+
+// ScanByType pages through all events of the given type in the table events
+// of source are stored in. The limit applies to items examined rather than
+// returned, so a page may be empty while the cursor is still set.
+func ScanByType(ctx context.Context, dbClient database.Reader, source EventSource, eventType EventType, cursor string, limit int32) (*Page, error) {
+	page := &Page{}
+	next, err := dbClient.Scan(ctx, store.table(source), database.Scan{
+		Filter: map[string]interface{}{"event_type": eventType},
+		Limit:  limit,
+		Cursor: cursor,
+	}, &page.Events)
+	if err != nil {
+		return nil, err
+	}
+	page.Cursor = next
+	return page, nil
+}
@@ -4,12 +4,23 @@ package eventbridge
 import (
 	"context"
 	"encoding/json"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/tracing"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("github.com/Babatunde13/event-pipeline/internal/eventbridge")
+
+// Traced is a detail that carries trace context in its body, since
+// EventBridge has no message headers.
+type Traced interface {
+	SetTraceContext(carrier map[string]string)
+}
+
 type Client struct {
 	ebClient *eventbridge.Client
 	busName  string
@@ -22,7 +33,22 @@ func New(cfg aws.Config, busName string) *Client {
 	}
 }
 
-func (c *Client) PutEvent(ctx context.Context, source, detailType string, detail interface{}) error {
+// PutEvent publishes detail to the bus. A Traced detail is given the trace
+// context of the publish span before it is marshalled.
+func (c *Client) PutEvent(ctx context.Context, source, detailType string, detail interface{}) (err error) {
+	ctx, span := tracer.Start(ctx, "eventbridge.PutEvents "+c.busName,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_eventbridge"),
+			attribute.String("messaging.destination.name", c.busName),
+			attribute.String("aws.eventbridge.source", source),
+			attribute.String("aws.eventbridge.detail_type", detailType),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	if t, ok := detail.(Traced); ok {
+		t.SetTraceContext(tracing.Inject(ctx))
+	}
 	payload, err := json.Marshal(detail)
 	if err != nil {
 		return err
@@ -39,7 +65,7 @@ func (c *Client) PutEvent(ctx context.Context, source, detailType string, detail
 		},
 	})
 	if err != nil {
-		log.Printf("Failed to publish to EventBridge: %v", err)
+		logging.Error(ctx, "failed to publish to EventBridge", "bus", c.busName, logging.Err(err))
 	}
 	return err
 }
@@ -2,15 +2,21 @@ package kafka
 
 import (
 	"context"
-	"log"
 	"time"
 
 	"github.com/Babatunde13/event-pipeline/internal/config"
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/Babatunde13/event-pipeline/internal/tracing"
 	"github.com/aws/aws-msk-iam-sasl-signer-go/signer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
+var tracer = tracing.Tracer("github.com/Babatunde13/event-pipeline/internal/kafka")
+
 type Producer struct {
 	producer *kafka.Producer
 }
@@ -32,6 +38,28 @@ func createTokenProvider() (*kafka.OAuthBearerToken, error) {
 	}
 	return &bearerToken, nil
 }
+
+// tokenClient is a Kafka client that authenticates with OAuth bearer
+// tokens.
+type tokenClient interface {
+	SetOAuthBearerToken(token kafka.OAuthBearerToken) error
+	SetOAuthBearerTokenFailure(errstr string) error
+}
+
+// refreshToken answers a kafka.OAuthBearerTokenRefresh, which librdkafka
+// raises as the IAM token nears expiry, with a new token.
+func refreshToken(ctx context.Context, client tokenClient) {
+	token, err := createTokenProvider()
+	if err != nil {
+		logging.Error(ctx, "failed to refresh Kafka token", logging.Err(err))
+		client.SetOAuthBearerTokenFailure(err.Error())
+		return
+	}
+	if err := client.SetOAuthBearerToken(*token); err != nil {
+		logging.Error(ctx, "failed to set Kafka token", logging.Err(err))
+	}
+}
+
 func getKafkaConfig() (*kafka.ConfigMap, *kafka.OAuthBearerToken, error) {
 	tokenProvider, err := createTokenProvider()
 	if err != nil {
@@ -55,9 +83,25 @@ func NewProducer() (*Producer, error) {
 		return nil, err
 	}
 	producer.SetOAuthBearerToken(*tokenProvider)
-	return &Producer{
+	p := &Producer{
 		producer: producer,
-	}, nil
+	}
+	go p.handleEvents()
+	return p, nil
+}
+
+// handleEvents reads the events that are not delivery reports, such as
+// token refresh requests, until the producer is closed.
+func (p *Producer) handleEvents() {
+	ctx := context.Background()
+	for ev := range p.producer.Events() {
+		switch e := ev.(type) {
+		case kafka.OAuthBearerTokenRefresh:
+			refreshToken(ctx, p.producer)
+		case kafka.Error:
+			logging.Warn(ctx, "Kafka producer error", logging.Err(e))
+		}
+	}
 }
 
 func CreateTopic(ctx context.Context, topicName string, numPartitions int, replicationFactor int) error {
@@ -72,11 +116,13 @@ func CreateTopic(ctx context.Context, topicName string, numPartitions int, repli
 	defer admin.Close()
 	admin.SetOAuthBearerToken(*tokenProvider)
 
-	// Define topic spec
+	// Define topic spec. Broker timestamps let the consumer time transport
+	// from when each record was appended.
 	topic := kafka.TopicSpecification{
 		Topic:             topicName,
 		NumPartitions:     numPartitions,
 		ReplicationFactor: replicationFactor,
+		Config:            map[string]string{"message.timestamp.type": "LogAppendTime"},
 	}
 	// Create topic
 	results, err := admin.CreateTopics(ctx, []kafka.TopicSpecification{topic}, kafka.SetAdminOperationTimeout(10*time.Second))
@@ -86,15 +132,26 @@ func CreateTopic(ctx context.Context, topicName string, numPartitions int, repli
 
 	for _, result := range results {
 		if result.Error.Code() != kafka.ErrNoError {
-			log.Printf("Failed to create topic %s: %v", result.Topic, result.Error)
+			logging.Error(ctx, "failed to create topic", logging.KeyTopic, result.Topic, logging.Err(result.Error))
 			return result.Error
 		}
-		log.Printf("Topic %s created successfully", result.Topic)
+		logging.Info(ctx, "topic created", logging.KeyTopic, result.Topic)
 	}
 	return nil
 }
 
-func (p *Producer) SendMessage(ctx context.Context, key string, value []byte) error {
+// SendMessage produces value and waits for the broker to acknowledge it. The
+// trace context of ctx travels in the message headers.
+func (p *Producer) SendMessage(ctx context.Context, key string, value []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "kafka.produce "+config.Cfg.KafkaTopic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", config.Cfg.KafkaTopic),
+			attribute.String("messaging.kafka.message.key", key),
+		))
+	defer func() { tracing.End(span, err) }()
+
 	msg := kafka.Message{
 		Key:   []byte(key),
 		Value: value,
@@ -103,8 +160,9 @@ func (p *Producer) SendMessage(ctx context.Context, key string, value []byte) er
 			Partition: kafka.PartitionAny,
 		},
 	}
+	otel.GetTextMapPropagator().Inject(ctx, (*headerCarrier)(&msg.Headers))
 	deliveryChan := make(chan kafka.Event, 1)
-	err := p.producer.Produce(&msg, deliveryChan)
+	err = p.producer.Produce(&msg, deliveryChan)
 	if err != nil {
 		return err
 	}
@@ -114,9 +172,50 @@ func (p *Producer) SendMessage(ctx context.Context, key string, value []byte) er
 	if m.TopicPartition.Error != nil {
 		return m.TopicPartition.Error
 	}
+	span.SetAttributes(
+		attribute.Int("messaging.kafka.destination.partition", int(m.TopicPartition.Partition)),
+		attribute.Int64("messaging.kafka.message.offset", int64(m.TopicPartition.Offset)),
+	)
 	return nil
 }
 
+// Close waits up to five seconds for outstanding messages to be delivered
+// and closes the producer.
+func (p *Producer) Close() {
+	p.producer.Flush(5000)
+	p.producer.Close()
+}
+
+// headerCarrier lets the otel propagator write message headers.
+type headerCarrier []kafka.Header
+
+func (c *headerCarrier) Get(key string) string {
+	for _, h := range *c {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c *headerCarrier) Set(key, value string) {
+	for i, h := range *c {
+		if h.Key == key {
+			(*c)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c = append(*c, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c *headerCarrier) Keys() []string {
+	keys := make([]string, len(*c))
+	for i, h := range *c {
+		keys[i] = h.Key
+	}
+	return keys
+}
+
 func NewConsumer(topic string, groupID string) (*Consumer, error) {
 	config, tokenProvider, err := getKafkaConfig()
 	if err != nil {
@@ -140,7 +239,7 @@ func NewConsumer(topic string, groupID string) (*Consumer, error) {
 		consumer.Close()
 		return nil, err
 	}
-	log.Printf("Kafka consumer initialized with topic: %s", topic)
+	logging.Info(context.Background(), "Kafka consumer initialized", logging.KeyTopic, topic)
 	return &Consumer{consumer: consumer}, nil
 }
 
@@ -0,0 +1,123 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const lagTimeoutMs = 10000
+
+// PartitionLag is how far a consumer group is behind on one partition.
+type PartitionLag struct {
+	Topic     string
+	Partition int32
+	Committed int64 // next offset the group will read; -1 when it never committed
+	Low       int64 // oldest offset still on the broker
+	High      int64 // offset the next produced message will get
+	Lag       int64 // messages between the committed offset and the high watermark
+}
+
+// LagReader reads the committed offsets of a consumer group and the
+// watermarks of the partitions it reads, without joining the group.
+type LagReader struct {
+	consumer *kafka.Consumer
+	group    string
+}
+
+// NewLagReader returns a LagReader for the consumer group groupID.
+func NewLagReader(groupID string) (*LagReader, error) {
+	config, tokenProvider, err := getKafkaConfig()
+	if err != nil {
+		return nil, err
+	}
+	// The group id only selects whose commits are read; the reader never
+	// subscribes, so it never joins the group or commits.
+	config.SetKey("group.id", groupID)
+	config.SetKey("enable.auto.commit", false)
+	consumer, err := kafka.NewConsumer(config)
+	if err != nil {
+		return nil, err
+	}
+	consumer.SetOAuthBearerToken(*tokenProvider)
+	return &LagReader{consumer: consumer, group: groupID}, nil
+}
+
+// Group is the consumer group the reader reports on.
+func (r *LagReader) Group() string {
+	return r.group
+}
+
+// Lag returns the lag of the group on every partition of topic.
+func (r *LagReader) Lag(ctx context.Context, topic string) ([]PartitionLag, error) {
+	r.refreshToken(ctx)
+
+	md, err := r.consumer.GetMetadata(&topic, false, lagTimeoutMs)
+	if err != nil {
+		return nil, err
+	}
+	tm, ok := md.Topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("topic %s not found", topic)
+	}
+	if tm.Error.Code() != kafka.ErrNoError {
+		return nil, tm.Error
+	}
+
+	partitions := make([]kafka.TopicPartition, len(tm.Partitions))
+	for i, p := range tm.Partitions {
+		partitions[i] = kafka.TopicPartition{Topic: &topic, Partition: p.ID}
+	}
+	committed, err := r.consumer.Committed(partitions, lagTimeoutMs)
+	if err != nil {
+		return nil, err
+	}
+
+	lags := make([]PartitionLag, 0, len(committed))
+	for _, tp := range committed {
+		low, high, err := r.consumer.QueryWatermarkOffsets(topic, tp.Partition, lagTimeoutMs)
+		if err != nil {
+			return nil, fmt.Errorf("watermarks of %s[%d]: %w", topic, tp.Partition, err)
+		}
+		l := PartitionLag{
+			Topic:     topic,
+			Partition: tp.Partition,
+			Committed: -1,
+			Low:       low,
+			High:      high,
+		}
+		if tp.Offset >= 0 {
+			l.Committed = int64(tp.Offset)
+			l.Lag = high - int64(tp.Offset)
+		} else {
+			// Nothing committed yet: with auto.offset.reset=earliest the
+			// group still has everything on the broker to read.
+			l.Lag = high - low
+		}
+		if l.Lag < 0 {
+			l.Lag = 0
+		}
+		lags = append(lags, l)
+	}
+	return lags, nil
+}
+
+// refreshToken handles the token refresh requests librdkafka queues as the
+// IAM token nears expiry. The reader never polls for messages, so it has to
+// look for them itself.
+func (r *LagReader) refreshToken(ctx context.Context) {
+	for {
+		ev := r.consumer.Poll(0)
+		if ev == nil {
+			return
+		}
+		if _, ok := ev.(kafka.OAuthBearerTokenRefresh); ok {
+			refreshToken(ctx, r.consumer)
+		}
+	}
+}
+
+func (r *LagReader) Close() error {
+	return r.consumer.Close()
+}
@@ -0,0 +1,199 @@
+package logging
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Correlation field names, kept the same in every service so that one
+// CloudWatch Logs Insights query works across all of them.
+const (
+	KeyService   = "service"
+	KeyEventID   = "event_id"
+	KeyEventType = "event_type"
+	KeySystem    = "system"
+	KeyTopic     = "topic"
+	KeyPartition = "partition"
+	KeyOffset    = "offset"
+	KeyRequestID = "request_id"
+	KeyTraceID   = "trace_id"
+	KeySpanID    = "span_id"
+	KeyError     = "error"
+)
+
+var (
+	level  = new(slog.LevelVar)
+	logger = newLogger(os.Stdout)
+
+	// sampleRate is the share of per-event lines that are written, between
+	// 0 and 1.
+	sampleRate = 1.0
+)
+
+func newLogger(w *os.File) *slog.Logger {
+	return slog.New(&contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+func init() {
+	slog.SetDefault(logger)
+}
+
+// Init names the service on every line and sets the level (debug, info,
+// warn or error) and the sample rate of per-event lines. A sample rate
+// outside (0, 1] keeps every line. Anything still written through the log
+// package comes out as JSON too.
+func Init(service, lvl string, rate float64) {
+	level.Set(ParseLevel(lvl))
+	if rate > 0 && rate <= 1 {
+		sampleRate = rate
+	} else {
+		sampleRate = 1
+	}
+	logger = newLogger(os.Stdout).With(KeyService, service)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+}
+
+// ParseLevel returns the slog level named by s, or info when s names none.
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+type fieldsKey struct{}
+
+// With returns ctx carrying the key-value pairs in args, which every line
+// logged with ctx then includes.
+func With(ctx context.Context, args ...interface{}) context.Context {
+	if len(args) == 0 {
+		return ctx
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	merged := make([]interface{}, 0, len(fields)+len(args))
+	merged = append(merged, fields...)
+	merged = append(merged, args...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// WithEvent adds the event's id and type to ctx.
+func WithEvent(ctx context.Context, eventID, eventType string) context.Context {
+	return With(ctx, KeyEventID, eventID, KeyEventType, eventType)
+}
+
+// WithRecord adds the position of a Kafka record to ctx.
+func WithRecord(ctx context.Context, topic string, partition, offset int64) context.Context {
+	return With(ctx, KeyTopic, topic, KeyPartition, partition, KeyOffset, offset)
+}
+
+// WithLambda adds the id of the Lambda invocation in ctx, if any, as the
+// request id.
+func WithLambda(ctx context.Context) context.Context {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		return With(ctx, KeyRequestID, lc.AwsRequestID)
+	}
+	return ctx
+}
+
+func Debug(ctx context.Context, msg string, args ...interface{}) {
+	logger.DebugContext(ctx, msg, args...)
+}
+
+func Info(ctx context.Context, msg string, args ...interface{}) {
+	logger.InfoContext(ctx, msg, args...)
+}
+
+func Warn(ctx context.Context, msg string, args ...interface{}) {
+	logger.WarnContext(ctx, msg, args...)
+}
+
+func Error(ctx context.Context, msg string, args ...interface{}) {
+	logger.ErrorContext(ctx, msg, args...)
+}
+
+// Fatal logs at error level and exits.
+func Fatal(ctx context.Context, msg string, args ...interface{}) {
+	logger.ErrorContext(ctx, msg, args...)
+	os.Exit(1)
+}
+
+// Sampled logs a per-event line at info level, subject to the sample rate.
+// Lines of an event in ctx are kept or dropped together, so a sampled event
+// can still be followed through every service.
+func Sampled(ctx context.Context, msg string, args ...interface{}) {
+	if !sampled(ctx) {
+		return
+	}
+	logger.InfoContext(ctx, msg, args...)
+}
+
+func sampled(ctx context.Context) bool {
+	if sampleRate >= 1 {
+		return true
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] != KeyEventID {
+			continue
+		}
+		if id, ok := fields[i+1].(string); ok && id != "" {
+			h := fnv.New32a()
+			h.Write([]byte(id))
+			return float64(h.Sum32()) < sampleRate*math.MaxUint32
+		}
+	}
+	return rand.Float64() < sampleRate
+}
+
+// Err is the attribute errors are logged under.
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.String(KeyError, "")
+	}
+	return slog.String(KeyError, err.Error())
+}
+
+// contextHandler adds the fields carried by the context and the ids of its
+// current span to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if fields, ok := ctx.Value(fieldsKey{}).([]interface{}); ok {
+			r.Add(fields...)
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(
+				slog.String(KeyTraceID, sc.TraceID().String()),
+				slog.String(KeySpanID, sc.SpanID().String()),
+			)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Babatunde13/event-pipeline/internal/config"
+	"github.com/Babatunde13/event-pipeline/internal/database"
+	"github.com/Babatunde13/event-pipeline/internal/database/archive"
+	"github.com/Babatunde13/event-pipeline/internal/database/postgres"
+	"github.com/Babatunde13/event-pipeline/internal/event"
+)
+
+const defaultSQLitePath = "events.db"
+
+// Open applies the event table settings from cfg and returns the database
+// processed events are written to: a fan-out over cfg.Sinks when any are
+// configured, otherwise the single database selected by cfg.DatabaseDriver.
+// Event tables are created on databases that manage their own tables.
+func Open(ctx context.Context, cfg *config.Config) (database.Database, error) {
+	if err := event.Configure(cfg); err != nil {
+		return nil, err
+	}
+
+	var db database.Database
+	if len(cfg.Sinks) == 0 {
+		w, err := openDriver(ctx, cfg, cfg.DatabaseDriver)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if db, ok = w.(database.Database); !ok {
+			// Write-only sinks still get the Database methods; reads fail.
+			db = database.NewFanOut(database.Sink{Name: cfg.DatabaseDriver, DB: w, Required: true})
+		}
+	} else {
+		names, err := sinkNames(cfg.Sinks)
+		if err != nil {
+			return nil, err
+		}
+		sinks := make([]database.Sink, 0, len(cfg.Sinks))
+		for i, sc := range cfg.Sinks {
+			sink, err := openSink(ctx, cfg, sc, names[i])
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		}
+		db = database.NewFanOut(sinks...)
+	}
+
+	if creator, ok := db.(database.TableCreator); ok {
+		if err := event.CreateTables(ctx, creator); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// sinkNames names each sink by its configured name, else by its driver,
+// numbered by position as driver#i when several sinks share the driver.
+func sinkNames(sinks []config.SinkConfig) ([]string, error) {
+	drivers := map[string]int{}
+	for _, sc := range sinks {
+		drivers[sinkDriver(sc)]++
+	}
+	names := make([]string, len(sinks))
+	seen := map[string]bool{}
+	for i, sc := range sinks {
+		switch {
+		case sc.Name != "":
+			names[i] = sc.Name
+		case drivers[sinkDriver(sc)] > 1:
+			names[i] = fmt.Sprintf("%s#%d", sinkDriver(sc), i)
+		default:
+			names[i] = sinkDriver(sc)
+		}
+		if seen[names[i]] {
+			return nil, fmt.Errorf("two sinks are named %s", names[i])
+		}
+		seen[names[i]] = true
+	}
+	return names, nil
+}
+
+func sinkDriver(sc config.SinkConfig) string {
+	if sc.Driver == "" {
+		return "dynamodb"
+	}
+	return strings.ToLower(sc.Driver)
+}
+
+func openSink(ctx context.Context, cfg *config.Config, sc config.SinkConfig, name string) (database.Sink, error) {
+	sink := database.Sink{
+		Name:     name,
+		Required: !sc.Optional,
+		Order:    sc.Order,
+	}
+	if sc.Timeout != "" {
+		timeout, err := time.ParseDuration(sc.Timeout)
+		if err != nil {
+			return sink, fmt.Errorf("invalid timeout for sink %s: %w", name, err)
+		}
+		sink.Timeout = timeout
+	}
+	db, err := openDriver(ctx, cfg, sc.Driver)
+	if err != nil {
+		return sink, err
+	}
+	sink.DB = db
+	return sink, nil
+}
+
+func openDriver(ctx context.Context, cfg *config.Config, driver string) (database.Writer, error) {
+	switch strings.ToLower(driver) {
+	case "", "dynamodb":
+		return database.NewDynamo(cfg.AwsConfig), nil
+	case "postgres":
+		// The events table is keyed by event_id; the pk and sk of the user
+		// layout only exist on the key-value databases.
+		if event.KeyLayout(strings.ToLower(cfg.EventsKeyLayout)) == event.LayoutUser {
+			return nil, fmt.Errorf("postgres does not support EVENTS_KEY_LAYOUT=%s", event.LayoutUser)
+		}
+		pg, err := postgres.New(ctx, cfg.PostgresDSN)
+		if err != nil {
+			return nil, fmt.Errorf("open postgres: %w", err)
+		}
+		return pg, nil
+	case "parquet":
+		opts := archive.Options{
+			Dir:        cfg.ArchiveDir,
+			Bucket:     cfg.ArchiveBucket,
+			Prefix:     cfg.ArchivePrefix,
+			Endpoint:   cfg.ArchiveEndpoint,
+			MaxRecords: cfg.ArchiveRecords(),
+		}
+		if cfg.ArchiveMaxAge != "" {
+			maxAge, err := time.ParseDuration(cfg.ArchiveMaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ARCHIVE_MAX_AGE: %w", err)
+			}
+			opts.MaxAge = maxAge
+		}
+		a, err := archive.New(opts, cfg.AwsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	case "memory":
+		return database.NewMemory(), nil
+	case "sqlite":
+		path := cfg.SQLitePath
+		if path == "" {
+			path = defaultSQLitePath
+		}
+		sqlite, err := database.NewSQLite(path)
+		if err != nil {
+			return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+		}
+		return sqlite, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
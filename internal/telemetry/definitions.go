@@ -0,0 +1,94 @@
+//go:generate go run ../../cmd/dashboards -dir ../../cmd/monitoring
+
+package telemetry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kind is the Prometheus type of a metric.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// Definition describes a pipeline metric: the collector is built from it,
+// and cmd/dashboards turns it into dashboard panels and alerting rules, so
+// a metric added here is charted without further work.
+type Definition struct {
+	Name    string
+	Help    string
+	Kind    Kind
+	Labels  []string
+	Buckets []float64 // histograms only
+
+	Group string   // dashboard row the panels go in
+	Title string   // panel title, Help when empty
+	Unit  string   // Grafana unit of the charted values, by Kind when empty
+	By    []string // labels the panels break the metric down by, Labels when nil
+	Ratio string   // a counter also charted as a share of this counter
+	Note  string   // panel description, Help when empty
+
+	Alerts []Alert
+}
+
+// Alert is a Prometheus alerting rule on a metric.
+type Alert struct {
+	Name     string
+	Expr     string
+	For      string // e.g. 5m
+	Severity string // warning or critical
+	Summary  string
+}
+
+// Dashboard rows, in the order they appear.
+const (
+	GroupThroughput = "Throughput"
+	GroupErrors     = "Errors"
+	GroupLatency    = "Latency"
+	GroupKafka      = "Kafka consumer lag"
+	GroupProducers  = "Producers"
+	GroupHealth     = "Pipeline health"
+)
+
+// Groups lists the dashboard rows in order.
+var Groups = []string{GroupThroughput, GroupErrors, GroupLatency, GroupKafka, GroupProducers, GroupHealth}
+
+var definitions []Definition
+
+// Definitions returns every pipeline metric in the order it was declared.
+func Definitions() []Definition {
+	return append([]Definition(nil), definitions...)
+}
+
+func newCounter(reg *prometheus.Registry, d Definition) *prometheus.CounterVec {
+	d.Kind = KindCounter
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: d.Name, Help: d.Help}, d.Labels)
+	define(reg, d, c)
+	return c
+}
+
+func newGauge(reg *prometheus.Registry, d Definition) *prometheus.GaugeVec {
+	d.Kind = KindGauge
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: d.Name, Help: d.Help}, d.Labels)
+	define(reg, d, g)
+	return g
+}
+
+func newHistogram(reg *prometheus.Registry, d Definition) *prometheus.HistogramVec {
+	d.Kind = KindHistogram
+	if d.Buckets == nil {
+		d.Buckets = latencyBuckets
+	}
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: d.Name, Help: d.Help, Buckets: d.Buckets}, d.Labels)
+	define(reg, d, h)
+	return h
+}
+
+func define(reg *prometheus.Registry, d Definition, c prometheus.Collector) {
+	reg.MustRegister(c)
+	definitions = append(definitions, d)
+}
@@ -0,0 +1,54 @@
+package telemetry
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	httpRequests = newCounter(registry, Definition{
+		Name:   "http_requests_total",
+		Help:   "Total number of HTTP requests handled by the producers",
+		Labels: []string{"system", "route", "method", "status"},
+		Group:  GroupProducers,
+		Title:  "Producer requests",
+		By:     []string{"system", "status"},
+		Alerts: []Alert{{
+			Name:     "ProducerErrorRateHigh",
+			Expr:     `sum by (system) (rate(http_requests_total{status=~"5.."}[5m])) / sum by (system) (rate(http_requests_total[5m])) > 0.05`,
+			For:      "5m",
+			Severity: "warning",
+			Summary:  "More than 5% of requests to the {{ $labels.system }} producer fail",
+		}},
+	})
+
+	httpDuration = newHistogram(registry, Definition{
+		Name:   "http_request_duration_seconds",
+		Help:   "Time the producers took to answer an HTTP request",
+		Labels: []string{"system", "route", "method"},
+		Group:  GroupProducers,
+		Title:  "Producer request duration",
+		By:     []string{"system"},
+	})
+)
+
+// GinMiddleware counts and times every request of a producer's router.
+// Requests are labelled by route pattern rather than path so that unknown
+// paths do not add series.
+func GinMiddleware(system string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(system, route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(system, route, method).Observe(time.Since(start).Seconds())
+		dirty.Store(true)
+	}
+}
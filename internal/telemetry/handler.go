@@ -0,0 +1,75 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// runtimeRegistry holds the Go runtime and process collectors. They are
+// served on /metrics but never pushed, since a Pushgateway would keep the
+// series of every Lambda instance forever.
+var runtimeRegistry = prometheus.NewRegistry()
+
+func init() {
+	runtimeRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the pipeline metrics together with the Go runtime and
+// process metrics, for Prometheus to scrape long-running services.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(
+		prometheus.Gatherers{registry, runtimeRegistry},
+		promhttp.HandlerOpts{},
+	)
+}
+
+// Serve serves Handler under /metrics on its own listener at addr. It blocks
+// like http.ListenAndServe.
+func Serve(addr string) error {
+	return serve(addr, Handler())
+}
+
+// ServeLag serves the consumer lag metrics with the runtime metrics under
+// /metrics on addr, for the lag exporter. It blocks like Serve.
+func ServeLag(addr string) error {
+	return serve(addr, promhttp.HandlerFor(
+		prometheus.Gatherers{lagRegistry, runtimeRegistry},
+		promhttp.HandlerOpts{},
+	))
+}
+
+func serve(addr string, h http.Handler) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	return http.ListenAndServe(addr, mux)
+}
+
+// ServeRouter runs a producer's router as a plain HTTP server on addr, for
+// running it in a container instead of Lambda. Prometheus scrapes /metrics
+// on the router, or on metricsAddr when a separate port is given. It blocks
+// until either server fails.
+func ServeRouter(r *gin.Engine, addr, metricsAddr string) error {
+	errs := make(chan error, 2)
+	if metricsAddr != "" {
+		go func() {
+			logging.Info(context.Background(), "serving metrics", "addr", metricsAddr, "path", "/metrics")
+			errs <- Serve(metricsAddr)
+		}()
+	} else {
+		r.GET("/metrics", gin.WrapH(Handler()))
+	}
+	go func() {
+		logging.Info(context.Background(), "starting HTTP server", "addr", addr)
+		errs <- r.Run(addr)
+	}()
+	return <-errs
+}
@@ -0,0 +1,87 @@
+package telemetry
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// lagRegistry holds the metrics only the lag exporter records, so they
+	// are neither pushed by the Lambdas nor served by the producers.
+	lagRegistry = prometheus.NewRegistry()
+
+	committedOffset = newGauge(lagRegistry, Definition{
+		Name:   "kafka_consumer_committed_offset",
+		Help:   "Next offset the consumer group will read, per partition",
+		Labels: []string{"group", "topic", "partition"},
+		Group:  GroupKafka,
+		Title:  "Committed offset",
+		By:     []string{"group"},
+	})
+
+	highWatermark = newGauge(lagRegistry, Definition{
+		Name:   "kafka_partition_high_watermark",
+		Help:   "Offset the next message produced to the partition will get",
+		Labels: []string{"topic", "partition"},
+		Group:  GroupKafka,
+		Title:  "High watermark",
+		By:     []string{"topic"},
+	})
+
+	consumerLag = newGauge(lagRegistry, Definition{
+		Name:   "kafka_consumer_lag",
+		Help:   "Messages the consumer group has yet to read, per partition",
+		Labels: []string{"group", "topic", "partition"},
+		Group:  GroupKafka,
+		Title:  "Consumer lag",
+		Alerts: []Alert{
+			{
+				Name:     "KafkaConsumerLagHigh",
+				Expr:     `sum by (group, topic) (kafka_consumer_lag) > 10000`,
+				For:      "10m",
+				Severity: "warning",
+				Summary:  "Consumer group {{ $labels.group }} is more than 10000 messages behind on {{ $labels.topic }}",
+			},
+			{
+				Name:     "KafkaConsumerLagGrowing",
+				Expr:     `sum by (group, topic) (deriv(kafka_consumer_lag[15m])) > 0 and sum by (group, topic) (kafka_consumer_lag) > 1000`,
+				For:      "30m",
+				Severity: "critical",
+				Summary:  "Consumer group {{ $labels.group }} keeps falling behind on {{ $labels.topic }}",
+			},
+		},
+	})
+
+	lagErrors = newCounter(lagRegistry, Definition{
+		Name:   "kafka_consumer_lag_errors_total",
+		Help:   "Total number of failed reads of consumer group offsets",
+		Labels: []string{"group", "topic"},
+		Group:  GroupHealth,
+		Title:  "Failed lag reads",
+		Alerts: []Alert{{
+			Name:     "KafkaLagUnreadable",
+			Expr:     `increase(kafka_consumer_lag_errors_total[10m]) > 0`,
+			For:      "15m",
+			Severity: "warning",
+			Summary:  "The lag exporter cannot read offsets of {{ $labels.group }}; lag panels are stale",
+		}},
+	})
+)
+
+// SetPartitionLag records the offsets of group on one partition of topic.
+// A negative committed offset means the group never committed and is not
+// exported.
+func SetPartitionLag(group, topic string, partition int32, committed, high, lag int64) {
+	p := strconv.Itoa(int(partition))
+	if committed >= 0 {
+		committedOffset.WithLabelValues(group, topic, p).Set(float64(committed))
+	}
+	highWatermark.WithLabelValues(topic, p).Set(float64(high))
+	consumerLag.WithLabelValues(group, topic, p).Set(float64(lag))
+}
+
+// RecordLagError counts a failed read of the offsets of group on topic.
+func RecordLagError(group, topic string) {
+	lagErrors.WithLabelValues(group, topic).Inc()
+}
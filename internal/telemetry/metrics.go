@@ -1,46 +1,295 @@
 package telemetry
 
 import (
-	"log"
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Babatunde13/event-pipeline/internal/logging"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/push"
 )
 
+const (
+	SystemKafka       = "kafka"
+	SystemEventBridge = "eventbridge"
+)
+
+// ErrorClass says why an event could not be processed.
+type ErrorClass string
+
+const (
+	ErrDecode           ErrorClass = "decode"     // the message was not a JSON event
+	ErrValidation       ErrorClass = "validation" // the event was missing required fields
+	ErrStorageThrottled ErrorClass = "storage_throttled"
+	ErrStorageOther     ErrorClass = "storage_other"
+)
+
+// latencyBuckets cover 1ms to 30s, the range between a warm hop and a
+// Lambda cold start behind a throttled table.
+var latencyBuckets = prometheus.ExponentialBucketsRange(0.001, 30, 18)
+
 var (
-	totalEvents = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "total_events",
-			Help: "Total number of processed events",
-		},
-		[]string{"system"}, // system = kafka | eventbridge
-	)
-
-	eventDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "event_duration_seconds",
-			Help:    "Event processing duration in seconds",
-			Buckets: prometheus.LinearBuckets(0.1, 0.1, 20), // 0.1s to 2s
+	receivedEvents = newCounter(registry, Definition{
+		Name:   "events_received_total",
+		Help:   "Total number of events received by the consumers",
+		Labels: []string{"system", "event_type"}, // system = kafka | eventbridge
+		Group:  GroupThroughput,
+		Title:  "Events received",
+	})
+
+	succeededEvents = newCounter(registry, Definition{
+		Name:   "events_succeeded_total",
+		Help:   "Total number of events stored by the consumers",
+		Labels: []string{"system", "event_type"},
+		Group:  GroupThroughput,
+		Title:  "Events stored",
+		Alerts: []Alert{{
+			Name:     "EventsNotStored",
+			Expr:     `sum by (system) (rate(events_received_total[5m])) > 0 and sum by (system) (rate(events_succeeded_total[5m])) == 0`,
+			For:      "5m",
+			Severity: "critical",
+			Summary:  "{{ $labels.system }} consumers receive events but store none",
+		}},
+	})
+
+	failedEvents = newCounter(registry, Definition{
+		Name:   "events_failed_total",
+		Help:   "Total number of events the consumers could not store, by error class",
+		Labels: []string{"system", "event_type", "error_class"},
+		Group:  GroupErrors,
+		Title:  "Failed events (dead-letter volume)",
+		Note:   "Events dropped after failing to decode, validate or store; these are what a dead-letter queue receives.",
+		By:     []string{"system", "error_class"},
+		Ratio:  "events_received_total",
+		Alerts: []Alert{
+			{
+				Name:     "EventErrorRateHigh",
+				Expr:     `sum by (system) (rate(events_failed_total[5m])) / sum by (system) (rate(events_received_total[5m])) > 0.05`,
+				For:      "5m",
+				Severity: "warning",
+				Summary:  "More than 5% of {{ $labels.system }} events fail",
+			},
+			{
+				Name:     "StorageThrottled",
+				Expr:     `sum by (system) (rate(events_failed_total{error_class="storage_throttled"}[5m])) > 0`,
+				For:      "10m",
+				Severity: "warning",
+				Summary:  "The {{ $labels.system }} consumer is dropping events because storage is throttling",
+			},
 		},
-		[]string{"system"}, // system = kafka | eventbridge
-	)
+	})
+
+	duplicateEvents = newCounter(registry, Definition{
+		Name:   "events_duplicate_total",
+		Help:   "Total number of redelivered events skipped instead of stored again",
+		Labels: []string{"system", "event_type"},
+		Group:  GroupErrors,
+		Title:  "Duplicate events",
+		Note:   "EventBridge counts every redelivery its conditional create finds already stored. Kafka only counts repeats within one batch; a record redelivered in a later batch is overwritten in place and not counted.",
+		By:     []string{"system"},
+		Ratio:  "events_received_total",
+	})
+
+	ingestLatency = newHistogram(registry, Definition{
+		Name:   "event_ingest_latency_seconds",
+		Help:   "Time from the producer receiving an event over HTTP to the broker acknowledging it",
+		Labels: []string{"system", "event_type"},
+		Group:  GroupLatency,
+		Title:  "Ingest latency",
+	})
+
+	transportLatency = newHistogram(registry, Definition{
+		Name:   "event_transport_latency_seconds",
+		Help:   "Time from the broker accepting an event to the consumer receiving it; for EventBridge, from before the PutEvents call",
+		Labels: []string{"system", "event_type"},
+		Group:  GroupLatency,
+		Title:  "Transport latency",
+		Note:   "Kafka measures from the broker's log append time. EventBridge gives no accept time, so its series starts before the producer's PutEvents call and includes it.",
+	})
+
+	sinkLatency = newHistogram(registry, Definition{
+		Name:   "event_sink_latency_seconds",
+		Help:   "Time from the consumer receiving an event to the sink write completing",
+		Labels: []string{"system", "event_type"},
+		Group:  GroupLatency,
+		Title:  "Sink latency",
+	})
+
+	endToEndLatency = newHistogram(registry, Definition{
+		Name:   "event_end_to_end_latency_seconds",
+		Help:   "Time from the producer receiving an event over HTTP to the sink write completing",
+		Labels: []string{"system", "event_type"},
+		Group:  GroupLatency,
+		Title:  "End-to-end latency",
+		Alerts: []Alert{{
+			Name:     "EndToEndLatencyHigh",
+			Expr:     `histogram_quantile(0.95, sum by (le, system) (rate(event_end_to_end_latency_seconds_bucket[5m]))) > 5`,
+			For:      "10m",
+			Severity: "warning",
+			Summary:  "p95 end-to-end latency of {{ $labels.system }} is above 5s",
+		}},
+	})
+
+	pushErrors = newCounter(registry, Definition{
+		Name:  "metrics_push_errors_total",
+		Help:  "Total number of failed pushes to the Pushgateway",
+		Group: GroupHealth,
+		Title: "Failed metric pushes",
+		Alerts: []Alert{{
+			Name:     "MetricsPushFailing",
+			Expr:     `increase(metrics_push_errors_total[10m]) > 0`,
+			For:      "10m",
+			Severity: "warning",
+			Summary:  "Lambdas cannot push metrics to the Pushgateway; dashboards are incomplete",
+		}},
+	}).WithLabelValues()
+
+	registry = prometheus.NewRegistry()
+
+	// dirty is set when something was recorded since the last push.
+	dirty atomic.Bool
 )
 
-func PushMetrics(url string, duration float64, isKafka, success bool) {
-	system := "eventbridge"
-	if isKafka {
-		system = "kafka"
+// ObserveIngest records how long the broker took to acknowledge an event
+// after the producer received it.
+func ObserveIngest(system, eventType string, d time.Duration) {
+	observe(ingestLatency, system, eventType, d)
+}
+
+// ObserveTransport records how long an event spent between the broker and
+// the consumer.
+func ObserveTransport(system, eventType string, d time.Duration) {
+	observe(transportLatency, system, eventType, d)
+}
+
+// ObserveSink records how long the consumer took to store an event.
+func ObserveSink(system, eventType string, d time.Duration) {
+	observe(sinkLatency, system, eventType, d)
+}
+
+// RecordReceived counts an event handed to a consumer. Nothing leaves the
+// process until a Pusher flushes. Like every eventType here, the label must
+// come from a bounded set, such as event.EventType.Label.
+func RecordReceived(system, eventType string) {
+	receivedEvents.WithLabelValues(system, eventType).Inc()
+	dirty.Store(true)
+}
+
+// RecordSuccess counts a stored event and records its end-to-end latency.
+func RecordSuccess(system, eventType string, endToEnd time.Duration) {
+	succeededEvents.WithLabelValues(system, eventType).Inc()
+	observe(endToEndLatency, system, eventType, endToEnd)
+	dirty.Store(true)
+}
+
+// RecordFailure counts an event that could not be stored.
+func RecordFailure(system, eventType string, class ErrorClass) {
+	failedEvents.WithLabelValues(system, eventType, string(class)).Inc()
+	dirty.Store(true)
+}
+
+// RecordDuplicate counts a redelivered event that was already stored.
+func RecordDuplicate(system, eventType string) {
+	duplicateEvents.WithLabelValues(system, eventType).Inc()
+	dirty.Store(true)
+}
+
+// observe skips non-positive durations, which come from missing hop
+// timestamps or clock skew between hosts.
+func observe(h *prometheus.HistogramVec, system, eventType string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	h.WithLabelValues(system, eventType).Observe(d.Seconds())
+	dirty.Store(true)
+}
+
+// Pusher sends the recorded metrics to a Pushgateway on an interval and
+// whenever Flush is called, so that pushing stays off the per-event path.
+type Pusher struct {
+	pusher *push.Pusher
+	mu     sync.Mutex
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewPusher returns a Pusher for the Pushgateway at url. An empty url gives
+// a Pusher that never pushes.
+//
+// Every process pushes its own cumulative counters, and a push replaces the
+// series of its group, so each pushes to a group of its own, keyed by
+// instance. Groups of Lambda environments that have gone away keep their
+// last values, which sums of rate() ignore.
+func NewPusher(url string) *Pusher {
+	p := &Pusher{}
+	if url != "" {
+		p.pusher = push.New(url, "event_pipeline").
+			Grouping("instance", instance()).
+			Gatherer(registry)
 	}
-	eventDuration.WithLabelValues(system).Observe(duration)
-	if success {
-		totalEvents.WithLabelValues(system).Inc()
+	return p
+}
+
+// instance identifies this process among those pushing: the Lambda
+// execution environment's log stream, which is unique to it, or the host.
+func instance() string {
+	if stream := os.Getenv("AWS_LAMBDA_LOG_STREAM_NAME"); stream != "" {
+		return stream
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// Start flushes every interval until Stop is called.
+func (p *Pusher) Start(interval time.Duration) {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.Flush()
+			}
+		}
+	}()
+}
+
+// Flush pushes the metrics if anything was recorded since the last push. A
+// failed push is counted in metrics_push_errors_total and retried on the
+// next flush.
+func (p *Pusher) Flush() error {
+	if p.pusher == nil || !dirty.Swap(false) {
+		return nil
 	}
-	err := push.New(url, "event_pipeline").
-		Collector(totalEvents).
-		Collector(eventDuration).
-		Add()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
+	err := p.pusher.Add()
 	if err != nil {
-		log.Printf("Failed to push metrics to Pushgateway: %v", err)
+		pushErrors.Inc()
+		dirty.Store(true)
+		logging.Error(context.Background(), "failed to push metrics to Pushgateway", logging.Err(err))
+	}
+	return err
+}
+
+// Stop ends the interval flushing and pushes whatever is left.
+func (p *Pusher) Stop() error {
+	if p.stop != nil {
+		close(p.stop)
+		<-p.done
+		p.stop = nil
 	}
+	return p.Flush()
 }
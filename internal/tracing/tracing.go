@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/Babatunde13/event-pipeline/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// provider is nil until Init sets up an exporter; spans then go to the
+// no-op provider otel starts with.
+var provider *sdktrace.TracerProvider
+
+// Init sends the spans of service to the OTLP/HTTP collector at endpoint,
+// e.g. http://localhost:4318. With no endpoint spans are dropped, but trace
+// context is still passed along so that downstream services can continue
+// the trace.
+func Init(ctx context.Context, service, endpoint string) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	if endpoint == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(service),
+	))
+	if err != nil {
+		return err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	logging.Info(ctx, "exporting traces", logging.KeyService, service, "endpoint", endpoint)
+	return nil
+}
+
+// Flush exports the spans ended so far. Lambdas call it before returning,
+// since the environment may be frozen before the batcher's next export.
+func Flush(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.ForceFlush(ctx); err != nil {
+		logging.Error(ctx, "failed to export traces", logging.Err(err))
+	}
+}
+
+// Shutdown exports the remaining spans and stops the exporter.
+func Shutdown(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.Shutdown(ctx); err != nil {
+		logging.Error(ctx, "failed to shut down tracing", logging.Err(err))
+	}
+}
+
+// Tracer returns the tracer of the named package.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Inject returns the trace context of ctx as a map, for carrying it in a
+// message body such as an EventBridge detail.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx carrying the trace context read from carrier.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}